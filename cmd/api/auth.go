@@ -121,6 +121,15 @@ func (j *Auth) GetAndVerifyTokenFromHeader(w http.ResponseWriter, r *http.Reques
 
 	token := headerParts[1]
 
+	claims, err := j.VerifyToken(token)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, claims, nil
+}
+
+func (j *Auth) VerifyToken(token string) (*Claims, error) {
 	claims := &Claims{}
 	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -131,14 +140,14 @@ func (j *Auth) GetAndVerifyTokenFromHeader(w http.ResponseWriter, r *http.Reques
 
 	if err != nil {
 		if strings.HasPrefix(err.Error(), "token is expired by") {
-			return "", nil, errors.New("expired token")
+			return nil, errors.New("expired token")
 		}
-		return "", nil, err
+		return nil, err
 	}
 
 	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("invalid issue")
+		return nil, errors.New("invalid issue")
 	}
 
-	return token, claims, nil
+	return claims, nil
 }
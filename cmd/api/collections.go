@@ -0,0 +1,259 @@
+﻿package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type collectionPayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	MovieIDs    []int  `json:"movie_ids"`
+}
+
+type relationPayload struct {
+	Type           string `json:"type"`
+	RelatedMovieID int    `json:"related_movie_id"`
+}
+
+func (app *application) Collections(w http.ResponseWriter, r *http.Request) {
+	collections, err := app.DB.Collections()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	streamJSON(w, http.StatusOK, collections)
+}
+
+func (app *application) Collection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.collectionFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, collection)
+}
+
+func (app *application) CreateCollection(w http.ResponseWriter, r *http.Request) {
+	var payload collectionPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	collection := models.Collection{
+		Name:        strings.TrimSpace(payload.Name),
+		Description: payload.Description,
+		Image:       payload.Image,
+		MovieIDs:    payload.MovieIDs,
+	}
+	if collection.Name == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	id, err := app.DB.InsertCollection(r.Context(), collection)
+	if err != nil {
+		app.collectionError(w, err)
+		return
+	}
+
+	app.wakeRelay()
+
+	app.writeCommitted(w, http.StatusCreated, "collection created", func() (interface{}, error) {
+		return app.DB.GetCollection(id)
+	})
+}
+
+// UpdateCollection replaces a collection's details. Its movies are only replaced when the
+// payload lists movie_ids.
+func (app *application) UpdateCollection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload collectionPayload
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	collection := models.Collection{
+		ID:          id,
+		Name:        strings.TrimSpace(payload.Name),
+		Description: payload.Description,
+		Image:       payload.Image,
+		MovieIDs:    payload.MovieIDs,
+	}
+	if collection.Name == "" {
+		app.errorJSON(w, errors.New("name is required"))
+		return
+	}
+
+	err = app.DB.UpdateCollection(r.Context(), collection)
+	if err != nil {
+		app.collectionError(w, err)
+		return
+	}
+
+	app.wakeRelay()
+
+	app.writeCommitted(w, http.StatusOK, "collection updated", func() (interface{}, error) {
+		return app.DB.GetCollection(id)
+	})
+}
+
+func (app *application) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeleteCollection(r.Context(), id)
+	if err != nil {
+		app.collectionError(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "collection deleted",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) AddMovieRelation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload relationPayload
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if err = validateRelation(id, payload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.AddMovieRelation(r.Context(), id, payload.RelatedMovieID, payload.Type)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	app.movieRelations(w, id, http.StatusCreated, "relation added")
+}
+
+func (app *application) RemoveMovieRelation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := relationPayload{Type: chi.URLParam(r, "type")}
+	payload.RelatedMovieID, err = strconv.Atoi(chi.URLParam(r, "related"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if err = validateRelation(id, payload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.RemoveMovieRelation(r.Context(), id, payload.RelatedMovieID, payload.Type)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("relation not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	app.movieRelations(w, id, http.StatusOK, "relation removed")
+}
+
+// movieRelations responds to a committed relation change with the movie's relations.
+func (app *application) movieRelations(w http.ResponseWriter, id, status int, message string) {
+	app.writeCommitted(w, status, message, func() (interface{}, error) {
+		relations, err := app.DB.RelationsForMovies([]int{id})
+		if err != nil {
+			return nil, err
+		}
+
+		if relations[id] == nil {
+			return []*models.MovieRelation{}, nil
+		}
+		return relations[id], nil
+	})
+}
+
+func validateRelation(id int, payload relationPayload) error {
+	if !slices.Contains(models.RelationTypes, payload.Type) {
+		return errors.New("type must be one of " + strings.Join(models.RelationTypes, ", "))
+	}
+	if payload.RelatedMovieID == id {
+		return repository.ErrSelfRelation
+	}
+	return nil
+}
+
+func (app *application) collectionFromURL(w http.ResponseWriter, r *http.Request) (*models.Collection, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	collection, err := app.DB.GetCollection(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("collection not found"), http.StatusNotFound)
+			return nil, false
+		}
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	return collection, true
+}
+
+func (app *application) collectionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, errors.New("collection not found"), http.StatusNotFound)
+	default:
+		app.errorJSON(w, err)
+	}
+}
@@ -0,0 +1,363 @@
+﻿package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, zstd", "zstd"},
+		{"GZIP", "gzip"},
+		{"zstd;q=0.5, gzip", "zstd"},
+		{"zstd;q=0, gzip", "gzip"},
+		{"zstd; q=0, gzip;q=0", ""},
+		{"*", "zstd"},
+		{"*, zstd;q=0", "gzip"},
+		{"gzip;q=0, *", "zstd"},
+		{"*;q=0", ""},
+		{"*;q=0, gzip", "gzip"},
+		{"br", ""},
+		{"br, identity", ""},
+		{"deflate, gzip", "gzip"},
+	}
+	for _, test := range tests {
+		if got := negotiateEncoding(test.header); got != test.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestCompressNegotiation(t *testing.T) {
+	app := &application{CompressMinSize: 16}
+	body := strings.Repeat(`{"title":"Casablanca"}`, 10)
+	handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip", "gzip"},
+		{"zstd, gzip", "zstd"},
+		{"gzip;q=0", ""},
+		{"*", "zstd"},
+		{"br", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if got := w.Header().Get("Content-Encoding"); got != test.want {
+			t.Errorf("Accept-Encoding %q: got Content-Encoding %q, want %q", test.acceptEncoding, got, test.want)
+			continue
+		}
+		if w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: missing Vary header", test.acceptEncoding)
+		}
+		if got := decode(t, test.want, w.Body.Bytes()); got != body {
+			t.Errorf("Accept-Encoding %q: got body %q", test.acceptEncoding, got)
+		}
+	}
+}
+
+func TestCompressWriterThreshold(t *testing.T) {
+	tests := []struct {
+		name       string
+		writes     []string
+		compressed bool
+	}{
+		{"below threshold", []string{"0123456789"}, false},
+		{"at threshold", []string{"0123456789abcdef"}, true},
+		{"reaches threshold across writes", []string{"01234567", "89abcdef", "more"}, true},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		cw := &compressWriter{ResponseWriter: w, encoding: "gzip", minSize: 16}
+		cw.Header().Set("Content-Type", "application/json")
+
+		for _, s := range test.writes {
+			if _, err := io.WriteString(cw, s); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if started := w.Header().Get("Content-Encoding") != ""; started != test.compressed {
+			t.Errorf("%s: encoder started before Close = %v, want %v", test.name, started, test.compressed)
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		encoding := w.Header().Get("Content-Encoding")
+		if (encoding == "gzip") != test.compressed {
+			t.Errorf("%s: got Content-Encoding %q", test.name, encoding)
+		}
+		if got, want := decode(t, encoding, w.Body.Bytes()), strings.Join(test.writes, ""); got != want {
+			t.Errorf("%s: got body %q, want %q", test.name, got, want)
+		}
+	}
+}
+
+func TestCompressWriterSkipsIncompressible(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"event stream", http.Header{"Content-Type": {"text/event-stream"}}},
+		{"image", http.Header{"Content-Type": {"image/png"}}},
+		{"already encoded", http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"br"}}},
+		{"short content length", http.Header{"Content-Type": {"application/json"}, "Content-Length": {"4"}}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		cw := &compressWriter{ResponseWriter: w, encoding: "gzip", minSize: 8}
+		for key, value := range test.header {
+			cw.Header()[key] = value
+		}
+
+		io.WriteString(cw, "0123456789")
+		cw.Close()
+
+		if got := w.Header().Get("Content-Encoding"); got == "gzip" {
+			t.Errorf("%s: response was compressed", test.name)
+		}
+		if got := w.Body.String(); got != "0123456789" {
+			t.Errorf("%s: got body %q", test.name, got)
+		}
+	}
+}
+
+func TestCompressWriterFlush(t *testing.T) {
+	// flushing buffered bytes settles the decision early rather than waiting for the threshold
+	w := httptest.NewRecorder()
+	cw := &compressWriter{ResponseWriter: w, encoding: "gzip", minSize: 1024}
+	cw.Header().Set("Content-Type", "application/json")
+
+	io.WriteString(cw, `{"id":1}`)
+	cw.Flush()
+
+	if !w.Flushed {
+		t.Fatal("Flush was not passed on")
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got Content-Encoding %q after Flush", w.Header().Get("Content-Encoding"))
+	}
+	flushed := w.Body.Len()
+	if flushed == 0 {
+		t.Fatal("Flush did not write the buffered bytes")
+	}
+
+	io.WriteString(cw, `{"id":2}`)
+	cw.Close()
+	if got := decode(t, "gzip", w.Body.Bytes()); got != `{"id":1}{"id":2}` {
+		t.Errorf("got body %q", got)
+	}
+
+	// with nothing buffered there is nothing to compress, so the response goes out as is
+	w = httptest.NewRecorder()
+	cw = &compressWriter{ResponseWriter: w, encoding: "gzip", minSize: 1024}
+	cw.Header().Set("Content-Type", "text/plain")
+	cw.Flush()
+	io.WriteString(cw, "event")
+	cw.Close()
+
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "event" {
+		t.Errorf("got Content-Encoding %q, body %q", w.Header().Get("Content-Encoding"), w.Body.String())
+	}
+}
+
+func TestCompressWriterPassesEmptyResponses(t *testing.T) {
+	for _, status := range []int{http.StatusNotModified, http.StatusNoContent} {
+		w := httptest.NewRecorder()
+		cw := &compressWriter{ResponseWriter: w, encoding: "gzip", minSize: 0}
+		cw.Header().Set("Content-Type", "application/json")
+		cw.Header().Set("ETag", `"1"`)
+
+		cw.WriteHeader(status)
+		cw.Close()
+
+		if w.Code != status {
+			t.Errorf("got status %d, want %d", w.Code, status)
+		}
+		if w.Header().Get("Content-Encoding") != "" || w.Body.Len() != 0 {
+			t.Errorf("%d: got Content-Encoding %q and %d body bytes", status, w.Header().Get("Content-Encoding"), w.Body.Len())
+		}
+		if got := w.Header().Get("ETag"); got != `"1"` {
+			t.Errorf("%d: got ETag %s", status, got)
+		}
+	}
+}
+
+func TestCompressETag(t *testing.T) {
+	app := &application{CompressMinSize: 16}
+	body := strings.Repeat("x", 32)
+	hashed := app.compress(app.conditionalGet("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	})))
+	versioned := app.compress(app.conditionalGet("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"3"`)
+		io.WriteString(w, body)
+	})))
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		acceptEncoding string
+		weak           bool
+	}{
+		{"hash, compressed", hashed, "gzip", true},
+		{"hash, identity", hashed, "", false},
+		{"version, compressed", versioned, "gzip", false},
+		{"version, identity", versioned, "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, r)
+
+		etag := w.Header().Get("ETag")
+		if etag == "" || strings.HasPrefix(etag, "W/") != test.weak {
+			t.Errorf("%s: got ETag %s", test.name, etag)
+		}
+	}
+}
+
+type movieRepo struct {
+	repository.DatabaseRepo
+	movie models.Movie
+}
+
+func (m *movieRepo) GetMovieByID(id int) (*models.Movie, error) {
+	movie := m.movie
+	return &movie, nil
+}
+
+func (m *movieRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	if movie.Version != m.movie.Version {
+		return repository.ErrVersionMismatch
+	}
+	m.movie = movie
+	m.movie.Version++
+	return nil
+}
+
+func TestCompressedGetThenPut(t *testing.T) {
+	repo := &movieRepo{movie: models.Movie{ID: 1, Title: strings.Repeat("Casablanca ", 20), Image: "/casablanca.jpg", Version: 4}}
+	app := &application{DB: repo, CompressMinSize: 16, auth: Auth{Issuer: "test", Audience: "test", Secret: "secret", TokenExpiry: time.Minute}}
+	tokens, err := app.auth.GenerateTokenPair(&jwtUser{ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := app.routes()
+
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/movies/1", nil)
+	r.Header.Set("Authorization", "Bearer "+tokens.Token)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got status %d, Content-Encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	etag := w.Header().Get("ETag")
+
+	r = httptest.NewRequest(http.MethodPut, "/api/admin/movies/1", strings.NewReader(`{"id":1,"title":"Casablanca"}`))
+	r.Header.Set("Authorization", "Bearer "+tokens.Token)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("If-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("PUT with the ETag %s from a compressed GET: got status %d, body %q", etag, w.Code, decode(t, w.Header().Get("Content-Encoding"), w.Body.Bytes()))
+	}
+	if got := w.Header().Get("ETag"); got != `"5"` {
+		t.Errorf("got ETag %s, want \"5\"", got)
+	}
+}
+
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+func TestCompressWriterHijack(t *testing.T) {
+	h := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	cw := &compressWriter{ResponseWriter: h, encoding: "gzip", minSize: 1024}
+	if _, _, err := cw.Hijack(); err != nil || !h.hijacked {
+		t.Fatalf("Hijack before any write: hijacked %v, err %v", h.hijacked, err)
+	}
+
+	// once bytes are buffered, handing over the connection would lose them
+	h = &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	cw = &compressWriter{ResponseWriter: h, encoding: "gzip", minSize: 1024}
+	cw.Header().Set("Content-Type", "application/json")
+	io.WriteString(cw, "{}")
+	if _, _, err := cw.Hijack(); err == nil || h.hijacked {
+		t.Errorf("Hijack after a buffered write: hijacked %v, err %v", h.hijacked, err)
+	}
+
+	h = &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	cw = &compressWriter{ResponseWriter: h, encoding: "gzip", minSize: 0}
+	cw.Header().Set("Content-Type", "application/json")
+	io.WriteString(cw, "{}")
+	if _, _, err := cw.Hijack(); err == nil || h.hijacked {
+		t.Errorf("Hijack after the response started: hijacked %v, err %v", h.hijacked, err)
+	}
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+
+	var r io.Reader
+	switch encoding {
+	case "":
+		return string(body)
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decoded)
+}
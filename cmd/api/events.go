@@ -0,0 +1,10 @@
+﻿package main
+
+// wakeRelay is called after every successful catalog mutation. The repository has already
+// written the audit entry, movie revision and outbox event in the mutation's transaction, so
+// the relay is woken to publish the event.
+func (app *application) wakeRelay() {
+	if app.relay != nil {
+		app.relay.Wake()
+	}
+}
@@ -0,0 +1,186 @@
+﻿package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type genrePayload struct {
+	Genre    string `json:"genre"`
+	ParentID *int   `json:"parent_id"`
+}
+
+func (app *application) CreateGenre(w http.ResponseWriter, r *http.Request) {
+	var payload genrePayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	name := strings.TrimSpace(payload.Genre)
+	if name == "" {
+		app.errorJSON(w, errors.New("genre is required"))
+		return
+	}
+
+	id, err := app.DB.CreateGenre(r.Context(), name, payload.ParentID)
+	if err != nil {
+		app.genreError(w, err)
+		return
+	}
+
+	app.wakeRelay()
+
+	app.writeCommitted(w, http.StatusCreated, "genre created", func() (interface{}, error) {
+		return app.DB.GetGenre(id)
+	})
+}
+
+// UpdateGenre replaces a genre's name and parent. A genre sent without parent_id becomes a
+// top-level genre.
+func (app *application) UpdateGenre(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload genrePayload
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	name := strings.TrimSpace(payload.Genre)
+	if name == "" {
+		app.errorJSON(w, errors.New("genre is required"))
+		return
+	}
+
+	err = app.DB.UpdateGenre(r.Context(), id, name, payload.ParentID)
+	if err != nil {
+		app.genreError(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	app.writeCommitted(w, http.StatusOK, "genre updated", func() (interface{}, error) {
+		return app.DB.GetGenre(id)
+	})
+}
+
+func (app *application) MergeGenre(w http.ResponseWriter, r *http.Request) {
+	source, ok := app.genreFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Into int `json:"into"`
+	}
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if payload.Into == source.ID {
+		app.errorJSON(w, errors.New("cannot merge a genre into itself"))
+		return
+	}
+
+	_, err = app.DB.GetGenre(payload.Into)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("target genre not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	movieIDs, err := app.DB.MergeGenres(r.Context(), source.ID, payload.Into)
+	if err != nil {
+		app.genreError(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	app.writeCommitted(w, http.StatusOK, "genres merged", func() (interface{}, error) {
+		genre, err := app.DB.GetGenre(payload.Into)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := struct {
+			Genre  *models.Genre `json:"genre"`
+			Movies []int         `json:"movies"`
+		}{genre, movieIDs}
+		if resp.Movies == nil {
+			resp.Movies = []int{}
+		}
+		return resp, nil
+	})
+}
+
+func (app *application) DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeleteGenre(r.Context(), id)
+	if err != nil {
+		app.genreError(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "genre deleted",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) genreFromURL(w http.ResponseWriter, r *http.Request) (*models.Genre, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	genre, err := app.DB.GetGenre(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("genre not found"), http.StatusNotFound)
+			return nil, false
+		}
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	return genre, true
+}
+
+func (app *application) genreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrGenreExists), errors.Is(err, repository.ErrGenreInUse),
+		errors.Is(err, repository.ErrGenreHasSubgenres):
+		app.errorJSON(w, err, http.StatusConflict)
+	case errors.Is(err, sql.ErrNoRows):
+		app.errorJSON(w, errors.New("genre not found"), http.StatusNotFound)
+	default:
+		app.errorJSON(w, err)
+	}
+}
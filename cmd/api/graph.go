@@ -0,0 +1,168 @@
+﻿package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"io"
+	"mime"
+	"movie-library/internal/graph"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const graphResponseMediaType = "application/graphql-response+json"
+
+// graphContext attaches the caller's claims to the request context when a valid token is present,
+// so that mutations can apply the same authorization as /api/admin.
+func (app *application) graphContext(w http.ResponseWriter, r *http.Request) context.Context {
+	if r.Header.Get("Authorization") == "" {
+		return r.Context()
+	}
+
+	_, claims, err := app.auth.GetAndVerifyTokenFromHeader(w, r)
+	if err != nil {
+		return r.Context()
+	}
+
+	return withClaims(r.Context(), claims)
+}
+
+func (app *application) graphAuthorize(ctx context.Context) error {
+	if _, ok := ctx.Value(claimsKey).(*Claims); !ok {
+		return errors.New("not authorized")
+	}
+	return nil
+}
+
+// readGraphRequest reads a GraphQL-over-HTTP request from the query string of a GET request
+// or from a POST body. An application/graphql body is a bare query document; any other body must
+// be a JSON object carrying query, variables, operationName and extensions.
+func (app *application) readGraphRequest(w http.ResponseWriter, r *http.Request) (graph.Request, error) {
+	var request graph.Request
+
+	if r.Method == http.MethodGet {
+		values := r.URL.Query()
+		request.Query = values.Get("query")
+		request.OperationName = values.Get("operationName")
+
+		if variables := values.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return request, errors.New("variables must be a JSON object")
+			}
+		}
+
+		if extensions := values.Get("extensions"); extensions != "" {
+			if err := json.Unmarshal([]byte(extensions), &request.Extensions); err != nil {
+				return request, errors.New("extensions must be a JSON object")
+			}
+		}
+
+		return request, nil
+	}
+
+	maxBytes := 1024 * 1024 //1mb
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		return request, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/graphql" {
+		return graph.Request{Query: string(body)}, nil
+	}
+
+	if err = json.Unmarshal(body, &request); err != nil {
+		return request, errors.New("invalid JSON body")
+	}
+
+	return request, nil
+}
+
+// writeGraphResult writes a GraphQL result. The status defaults to 200, or to 400 for a
+// result without data when the client accepts application/graphql-response+json.
+func (app *application) writeGraphResult(w http.ResponseWriter, r *http.Request, resp *graphql.Result, statusCode ...int) {
+	status := http.StatusOK
+	contentType := "application/json"
+
+	if strings.Contains(r.Header.Get("Accept"), graphResponseMediaType) {
+		contentType = graphResponseMediaType
+		if resp.Data == nil && len(resp.Errors) > 0 {
+			status = http.StatusBadRequest
+		}
+	}
+
+	if len(statusCode) > 0 {
+		status = statusCode[0]
+	}
+
+	var payload interface{} = resp
+	if resp.Data == nil {
+		payload = struct {
+			Errors     []gqlerrors.FormattedError `json:"errors,omitempty"`
+			Extensions map[string]interface{}     `json:"extensions,omitempty"`
+		}{resp.Errors, resp.Extensions}
+	}
+
+	j, err := json.MarshalIndent(payload, "", "\t")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(j)
+}
+
+// graphLimitsFromEnv overrides the default GraphQL limits with GRAPHQL_MAX_DEPTH,
+// GRAPHQL_MAX_COMPLEXITY, GRAPHQL_TIMEOUT and GRAPHQL_FIELD_COSTS when they are set.
+func graphLimitsFromEnv() (graph.Limits, error) {
+	limits := graph.DefaultLimits
+
+	ints := map[string]*int{
+		"GRAPHQL_MAX_DEPTH":      &limits.MaxDepth,
+		"GRAPHQL_MAX_COMPLEXITY": &limits.MaxComplexity,
+	}
+	for name, target := range ints {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return limits, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = n
+		}
+	}
+
+	if value := os.Getenv("GRAPHQL_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return limits, fmt.Errorf("invalid GRAPHQL_TIMEOUT: %w", err)
+		}
+		limits.Timeout = timeout
+	}
+
+	if value := os.Getenv("GRAPHQL_FIELD_COSTS"); value != "" {
+		costs, err := graph.ParseFieldCosts(value)
+		if err != nil {
+			return limits, err
+		}
+
+		merged := make(map[string]int, len(limits.FieldCosts)+len(costs))
+		for field, cost := range limits.FieldCosts {
+			merged[field] = cost
+		}
+		for field, cost := range costs {
+			merged[field] = cost
+		}
+		limits.FieldCosts = merged
+	}
+
+	return limits, nil
+}
@@ -0,0 +1,31 @@
+﻿package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:generate curl -sSfL -o assets/graphiql/react.production.min.js https://unpkg.com/react@18.3.1/umd/react.production.min.js
+//go:generate curl -sSfL -o assets/graphiql/react-dom.production.min.js https://unpkg.com/react-dom@18.3.1/umd/react-dom.production.min.js
+//go:generate curl -sSfL -o assets/graphiql/graphiql.min.js https://unpkg.com/graphiql@3.7.1/graphiql.min.js
+//go:generate curl -sSfL -o assets/graphiql/graphiql.min.css https://unpkg.com/graphiql@3.7.1/graphiql.min.css
+
+//go:embed assets/graphiql.html
+var graphiqlPage []byte
+
+// graphiqlAssets holds the vendored scripts and styles the GraphiQL page loads.
+//
+//go:embed assets/graphiql
+var graphiqlAssets embed.FS
+
+func (app *application) GraphiQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(graphiqlPage)
+}
+
+func (app *application) GraphiQLAssets() http.Handler {
+	assets, _ := fs.Sub(graphiqlAssets, "assets/graphiql")
+	return http.StripPrefix("/api/graphiql/assets/", http.FileServer(http.FS(assets)))
+}
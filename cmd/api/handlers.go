@@ -1,18 +1,24 @@
 ﻿package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
 	"io"
 	"log"
-	"movie-library/internal/graph"
+	"mime"
 	"movie-library/internal/models"
+	"movie-library/internal/repository"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
+	"time"
 )
 
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
@@ -40,14 +46,20 @@ func (app *application) Genres(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) Movies(w http.ResponseWriter, r *http.Request) {
-	genreID, err := strconv.Atoi(r.URL.Query().Get("genre"))
-	var movies []*models.Movie
-	if err != nil {
-		app.errorJSON(w, err)
-		return
+	var genreID int
+	var err error
+	if value := r.URL.Query().Get("genre"); value != "" {
+		genreID, err = strconv.Atoi(value)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
 	}
 
-	if genreID != 0 {
+	var movies []*models.Movie
+	if tags := tagsParam(r); len(tags) > 0 {
+		movies, err = app.DB.MoviesByTags(tags, genreID)
+	} else if genreID != 0 {
 		movies, err = app.DB.AllMovies(genreID)
 	} else {
 		movies, err = app.DB.AllMovies()
@@ -58,7 +70,9 @@ func (app *application) Movies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, movies)
+	headers := http.Header{}
+	headers.Set("ETag", moviesETag(movies))
+	streamJSON(w, http.StatusOK, movies, headers)
 }
 
 func (app *application) Movie(w http.ResponseWriter, r *http.Request) {
@@ -75,9 +89,30 @@ func (app *application) Movie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, movie)
+	entries, err := app.DB.CollectionEntriesForMovies([]int{id})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	movie.Collections = entries[id]
+
+	relations, err := app.DB.RelationsForMovies([]int{id})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	movie.Relations = relations[id]
+
+	// the version changes whenever anything embedded in the response does, so the public and
+	// admin representations share the ETag used for If-Match
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(movie))
+	app.writeJSON(w, http.StatusOK, movie, headers)
 }
 
+// PostCreateMovie adds a movie unless it looks like one already in the library, in which case
+// it responds 409 with the existing movie. A match on title and release year alone can be
+// overridden with ?force=true; a shared external ID cannot.
 func (app *application) PostCreateMovie(w http.ResponseWriter, r *http.Request) {
 	var movie models.Movie
 	err := app.readJSON(w, r, &movie)
@@ -87,18 +122,35 @@ func (app *application) PostCreateMovie(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie = app.GetMoviePoster(movie)
-	newId, err := app.DB.CreateMovie(movie)
+	if err = models.NormalizeExternalIDs(&movie); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	duplicate, err := app.DB.FindDuplicateMovie(movie)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	err = app.DB.CreateMovieGenre(newId, movie.GenresArray)
+	force := r.URL.Query().Get("force") == "true"
+	if duplicate != nil && (duplicate.Reason != models.DuplicateTitle || !force) {
+		app.duplicateMovie(w, duplicate)
+		return
+	}
+
+	movie = app.GetMoviePoster(movie)
+	_, err = app.DB.CreateMovie(r.Context(), movie)
 	if err != nil {
+		if errors.Is(err, repository.ErrDuplicateExternalID) {
+			app.errorJSON(w, err, http.StatusConflict)
+			return
+		}
 		app.errorJSON(w, err)
 		return
 	}
+	app.wakeRelay()
+
 	resp := JSONResponse{
 		Error:   false,
 		Message: "movie updated",
@@ -107,6 +159,21 @@ func (app *application) PostCreateMovie(w http.ResponseWriter, r *http.Request)
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
 
+func (app *application) duplicateMovie(w http.ResponseWriter, duplicate *models.Duplicate) {
+	message := fmt.Sprintf("a movie with the same %s already exists", duplicate.Reason)
+	if duplicate.Reason == models.DuplicateTitle {
+		message = "a movie with the same title was released the same year; add ?force=true to create it anyway"
+	}
+
+	resp := JSONResponse{
+		Error:   true,
+		Message: message,
+		Data:    duplicate,
+	}
+
+	app.writeJSON(w, http.StatusConflict, resp)
+}
+
 func (app *application) PutUpdateMovie(w http.ResponseWriter, r *http.Request) {
 	var movie models.Movie
 	err := app.readJSON(w, r, &movie)
@@ -116,23 +183,212 @@ func (app *application) PutUpdateMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.DB.UpdateMovie(movie)
-	if err != nil {
+	if err = models.NormalizeExternalIDs(&movie); err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	err = app.DB.CreateMovieGenre(movie.ID, movie.GenresArray)
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		app.errorJSON(w, errors.New("If-Match header is required"), http.StatusPreconditionRequired)
+		return
+	}
+
+	before, err := app.DB.GetMovieByID(movie.ID)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
+
+	if !strongETagMatch(ifMatch, movieETag(before)) {
+		app.errorJSON(w, repository.ErrVersionMismatch, http.StatusPreconditionFailed)
+		return
+	}
+	movie.Version = before.Version
+	models.KeepExternalIDs(&movie, before)
+
+	if movie.Image == "" {
+		movie.Image = before.Image
+	}
+	if movie.Image == "" {
+		movie = app.GetMoviePoster(movie)
+	}
+	// a full update replaces the genres too, so no genres means none rather than unchanged
+	if movie.GenresArray == nil {
+		movie.GenresArray = []int{}
+	}
+
+	err = app.DB.UpdateMovie(r.Context(), movie)
+	if err != nil {
+		app.movieWriteError(w, err)
+		return
+	}
+	app.wakeRelay()
+
 	resp := JSONResponse{
 		Error:   false,
 		Message: "movie updated",
 	}
 
-	app.writeJSON(w, http.StatusAccepted, resp)
+	// the update matched the version and bumped it by one
+	movie.Version++
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(&movie))
+	app.writeJSON(w, http.StatusAccepted, resp, headers)
+}
+
+// movieWriteError responds to a failed movie update.
+func (app *application) movieWriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repository.ErrVersionMismatch):
+		app.errorJSON(w, err, http.StatusPreconditionFailed)
+	case errors.Is(err, repository.ErrDuplicateExternalID):
+		app.errorJSON(w, err, http.StatusConflict)
+	default:
+		app.errorJSON(w, err)
+	}
+}
+
+// patchableMovieFields maps the JSON members accepted in a merge patch to movie columns.
+var patchableMovieFields = map[string]string{
+	"title":        "title",
+	"description":  "description",
+	"release_date": "release_date",
+	"run_time":     "runtime",
+	"mpaa_rating":  "mpaa_rating",
+	"image":        "image",
+	"imdb_id":      "imdb_id",
+	"tmdb_id":      "tmdb_id",
+	"wikidata_id":  "wikidata_id",
+}
+
+func (app *application) PatchUpdateMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		app.errorJSON(w, errors.New("content type must be application/merge-patch+json"), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var patch map[string]interface{}
+	err = app.readJSON(w, r, &patch)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	for field := range patch {
+		if _, ok := patchableMovieFields[field]; !ok && field != "genres_array" {
+			app.errorJSON(w, fmt.Errorf("field %s cannot be patched", field))
+			return
+		}
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		app.errorJSON(w, errors.New("If-Match header is required"), http.StatusPreconditionRequired)
+		return
+	}
+
+	before, err := app.DB.GetMovieByID(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !strongETagMatch(ifMatch, movieETag(before)) {
+		app.errorJSON(w, repository.ErrVersionMismatch, http.StatusPreconditionFailed)
+		return
+	}
+
+	current, err := json.Marshal(before)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var target, original map[string]interface{}
+	err = json.Unmarshal(current, &target)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	_ = json.Unmarshal(current, &original)
+
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var patched models.Movie
+	err = json.Unmarshal(merged, &patched)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if patched.Title == "" {
+		app.errorJSON(w, errors.New("title is required"))
+		return
+	}
+
+	if err = models.NormalizeExternalIDs(&patched); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	values := map[string]interface{}{
+		"title":        patched.Title,
+		"description":  patched.Description,
+		"release_date": patched.ReleaseDate,
+		"runtime":      patched.RunTime,
+		"mpaa_rating":  patched.MPAARating,
+		"image":        patched.Image,
+		"imdb_id":      patched.IMDbID,
+		"tmdb_id":      patched.TMDBID,
+		"wikidata_id":  patched.WikidataID,
+	}
+
+	changes := make(map[string]interface{})
+	for field, column := range patchableMovieFields {
+		if _, ok := patch[field]; ok && !reflect.DeepEqual(original[field], target[field]) {
+			changes[column] = values[column]
+		}
+	}
+
+	_, genresPatched := patch["genres_array"]
+	genresChanged := genresPatched && !reflect.DeepEqual(before.GenresArray, patched.GenresArray)
+
+	version := before.Version
+	if len(changes) > 0 || genresChanged {
+		var genreIDs []int
+		if genresChanged {
+			genreIDs = patched.GenresArray
+			if genreIDs == nil {
+				genreIDs = []int{}
+			}
+		}
+
+		err = app.DB.PatchMovie(r.Context(), id, before.Version, changes, genreIDs)
+		if err != nil {
+			app.movieWriteError(w, err)
+			return
+		}
+		app.wakeRelay()
+		version++
+	}
+
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(&models.Movie{Version: version}))
+	app.writeCommitted(w, http.StatusOK, "movie updated", func() (interface{}, error) {
+		return app.DB.GetMovieByID(id)
+	}, headers)
 }
 
 func (app *application) CreateMovie(w http.ResponseWriter, r *http.Request) {
@@ -153,7 +409,9 @@ func (app *application) CreateMovie(w http.ResponseWriter, r *http.Request) {
 		Movies *models.Movie `json:"movies"`
 	}{movie}
 
-	app.writeJSON(w, http.StatusOK, payload)
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(movie))
+	app.writeJSON(w, http.StatusOK, payload, headers)
 }
 
 func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
@@ -163,12 +421,17 @@ func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.DB.DeleteMovie(id)
+	err = app.DB.DeleteMovie(r.Context(), id)
 
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
 		app.errorJSON(w, err)
 		return
 	}
+	app.wakeRelay()
 
 	payload := struct {
 		Error   bool   `json:"error"`
@@ -178,6 +441,271 @@ func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
+func (app *application) MovieRevisions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	revisions, err := app.DB.MovieRevisions(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, revisions)
+}
+
+func (app *application) MovieRevision(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	revisionNumber, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	revision, err := app.DB.GetMovieRevision(id, revisionNumber)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, revision)
+}
+
+func (app *application) MovieRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid from revision"))
+		return
+	}
+
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid to revision"))
+		return
+	}
+
+	fromRevision, err := app.DB.GetMovieRevision(id, from)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	toRevision, err := app.DB.GetMovieRevision(id, to)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	fromJSON, err := json.Marshal(fromRevision.Snapshot)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	toJSON, err := json.Marshal(toRevision.Snapshot)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := struct {
+		From int                           `json:"from"`
+		To   int                           `json:"to"`
+		Diff map[string]models.FieldChange `json:"diff"`
+	}{from, to, models.DiffJSON(fromJSON, toJSON)}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *application) RevertMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	revisionNumber, err := strconv.Atoi(chi.URLParam(r, "revision"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	revision, err := app.DB.GetMovieRevision(id, revisionNumber)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	before, err := app.DB.GetMovieByID(id)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !strongETagMatch(ifMatch, movieETag(before)) {
+		app.errorJSON(w, repository.ErrVersionMismatch, http.StatusPreconditionFailed)
+		return
+	}
+
+	movie := *revision.Snapshot
+	movie.ID = id
+	movie.Version = before.Version
+	// revisions recorded before external IDs existed carry none
+	models.KeepExternalIDs(&movie, before)
+	if movie.Image == "" {
+		movie.Image = before.Image
+	}
+	if movie.GenresArray == nil {
+		movie.GenresArray = []int{}
+	}
+
+	err = app.DB.RevertMovie(r.Context(), movie)
+	if err != nil {
+		app.movieWriteError(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	movie.Version++
+	headers := http.Header{}
+	headers.Set("ETag", movieETag(&movie))
+	app.writeCommitted(w, http.StatusOK, "movie reverted", func() (interface{}, error) {
+		return app.DB.GetMovieByID(id)
+	}, headers)
+}
+
+func (app *application) Trash(w http.ResponseWriter, r *http.Request) {
+	movies, err := app.DB.DeletedMovies()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	streamJSON(w, http.StatusOK, movies)
+}
+
+func (app *application) RestoreMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.RestoreMovie(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found in trash"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie restored",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) PurgeMovie(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.PurgeMovie(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found in trash"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "movie purged",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) AuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.AuditFilter{
+		EntityType: query.Get("entity"),
+		Action:     query.Get("action"),
+		Limit:      100,
+	}
+
+	var err error
+	intParams := map[string]*int{
+		"entity_id": &filter.EntityID,
+		"actor":     &filter.ActorID,
+		"limit":     &filter.Limit,
+		"offset":    &filter.Offset,
+	}
+	for name, target := range intParams {
+		if value := query.Get(name); value != "" {
+			*target, err = strconv.Atoi(value)
+			if err != nil {
+				app.errorJSON(w, fmt.Errorf("invalid %s", name))
+				return
+			}
+		}
+	}
+
+	timeParams := map[string]*time.Time{
+		"since": &filter.Since,
+		"until": &filter.Until,
+	}
+	for name, target := range timeParams {
+		if value := query.Get(name); value != "" {
+			*target, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				app.errorJSON(w, fmt.Errorf("invalid %s, expected RFC3339 timestamp", name))
+				return
+			}
+		}
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 500
+	}
+
+	entries, err := app.DB.AuditEntries(filter)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	streamJSON(w, http.StatusOK, entries)
+}
+
+func (app *application) CacheStats(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, http.StatusOK, app.cache.Stats())
+}
+
 func (app *application) Authenticate(w http.ResponseWriter, r *http.Request) {
 
 	var requestPayload struct {
@@ -281,7 +809,7 @@ func (app *application) MovieCatalog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, movies)
+	streamJSON(w, http.StatusOK, movies)
 }
 
 func (app *application) GetMoviePoster(movie models.Movie) models.Movie {
@@ -344,27 +872,57 @@ func (app *application) GetMoviesByGenre(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, movies)
+	streamJSON(w, http.StatusOK, movies)
 }
 
 func (app *application) GraphQL(w http.ResponseWriter, r *http.Request) {
+	request, err := app.readGraphRequest(w, r)
+	if err != nil {
+		app.writeGraphResult(w, r, &graphql.Result{Errors: gqlerrors.FormatErrors(err)}, http.StatusBadRequest)
+		return
+	}
 
-	movies, _ := app.DB.AllMovies()
+	if r.Method == http.MethodGet && app.graph.OperationType(request) == "mutation" {
+		w.Header().Set("Allow", http.MethodPost)
+		app.errorJSON(w, errors.New("mutations must use POST"), http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := app.graph.Query(app.graphContext(w, r), request)
+	app.writeGraphResult(w, r, resp)
+}
+
+func (app *application) PersistedQueries(w http.ResponseWriter, r *http.Request) {
+	if app.graph.Persisted == nil {
+		app.errorJSON(w, errors.New("persisted queries are not enabled"), http.StatusNotFound)
+		return
+	}
 
-	q, _ := io.ReadAll(r.Body)
-	query := string(q)
-	g := graph.New(movies)
-	g.QueryString = query
+	app.writeJSON(w, http.StatusOK, app.graph.Persisted.Hashes())
+}
+
+func (app *application) RegisterPersistedQuery(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Query string `json:"query"`
+	}
 
-	resp, err := g.Query()
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
+	hash, err := app.graph.RegisterQuery(r.Context(), requestPayload.Query)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	j, _ := json.MarshalIndent(resp, "", "\t")
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	resp := JSONResponse{
+		Error:   false,
+		Message: "query registered",
+		Data:    map[string]string{"sha256Hash": hash},
+	}
+
+	app.writeJSON(w, http.StatusCreated, resp)
 }
@@ -1,28 +1,48 @@
 ﻿package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/joho/godotenv"
 	"log"
+	"movie-library/internal/events"
+	"movie-library/internal/graph"
+	"movie-library/internal/invalidation"
+	"movie-library/internal/outbox"
 	"movie-library/internal/repository"
+	"movie-library/internal/repository/cacherepo"
 	"movie-library/internal/repository/dbrepo"
+	"movie-library/internal/webhooks"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 )
 
 const port = 8080
 
 type application struct {
-	Domain        string
-	DSN           string
-	DB            repository.DatabaseRepo
-	auth          Auth
-	JWTIssuer     string
-	JWTAudience   string
-	CookieDomain  string
-	JWTSecret     string
-	MovieDBAPIKey string
+	Domain         string
+	DSN            string
+	DB             repository.DatabaseRepo
+	graph          *graph.Graph
+	events         *events.Bus
+	webhooks       *webhooks.Dispatcher
+	relay          *outbox.Relay
+	invalidation   *invalidation.Hub
+	cache          *cacherepo.CachedRepo
+	auth           Auth
+	JWTIssuer      string
+	JWTAudience    string
+	CookieDomain   string
+	JWTSecret      string
+	MovieDBAPIKey  string
+	TrashRetention time.Duration
+	DevMode        bool
+	// CompressMinSize is the smallest response body, in bytes, that is compressed.
+	CompressMinSize int
+	// CacheControl holds the Cache-Control policy of each cacheable public route.
+	CacheControl map[string]string
 }
 
 func main() {
@@ -47,6 +67,40 @@ func main() {
 	app.CookieDomain = os.Getenv("COOKIE_DOMAIN")
 	app.Domain = os.Getenv("DOMAIN")
 	app.MovieDBAPIKey = os.Getenv("MOVIE_DB_API_KEY")
+	app.DevMode = os.Getenv("APP_ENV") == "development"
+
+	app.CompressMinSize = 1024
+	if size := os.Getenv("COMPRESS_MIN_SIZE"); size != "" {
+		app.CompressMinSize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal("invalid COMPRESS_MIN_SIZE", err)
+		}
+	}
+
+	// responses carry ETags, so by default clients may store them but must revalidate
+	app.CacheControl = map[string]string{
+		"/api/movies":      "public, no-cache",
+		"/api/movies/{id}": "public, no-cache",
+		"/api/genres":      "public, no-cache",
+	}
+	cacheControlEnv := map[string]string{
+		"/api/movies":      "CACHE_CONTROL_MOVIES",
+		"/api/movies/{id}": "CACHE_CONTROL_MOVIE",
+		"/api/genres":      "CACHE_CONTROL_GENRES",
+	}
+	for route, env := range cacheControlEnv {
+		if policy := os.Getenv(env); policy != "" {
+			app.CacheControl[route] = policy
+		}
+	}
+
+	app.TrashRetention = time.Hour * 24 * 30
+	if retention := os.Getenv("TRASH_RETENTION"); retention != "" {
+		app.TrashRetention, err = time.ParseDuration(retention)
+		if err != nil {
+			log.Fatal("invalid TRASH_RETENTION", err)
+		}
+	}
 
 	app.DSN = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable timezone=UTC connect_timeout=5", dbHost, dbPort, dbUser, dbPassword, dbName)
 
@@ -56,9 +110,80 @@ func main() {
 		log.Fatal(err)
 	}
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	cacheTTL := time.Minute * 5
+	if ttl := os.Getenv("CACHE_TTL"); ttl != "" {
+		cacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal("invalid CACHE_TTL", err)
+		}
+	}
+
+	cacheMaxEntries := 1000
+	if size := os.Getenv("CACHE_MAX_ENTRIES"); size != "" {
+		cacheMaxEntries, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal("invalid CACHE_MAX_ENTRIES", err)
+		}
+	}
+
+	app.cache = cacherepo.New(&dbrepo.PostgresDBRepo{DB: conn}, cacheTTL, cacheMaxEntries)
+	app.DB = app.cache
 	defer app.DB.Connection().Close()
 
+	// in-process state derived from the database registers with the hub: the catalog cache,
+	// the feed publishing every replica's events on the local bus and the persisted queries.
+	// The listener keeps them in step with changes made through other replicas.
+	app.invalidation = invalidation.NewHub()
+	app.invalidation.Register(app.cache)
+
+	replaySize := 1000
+	if size := os.Getenv("EVENTS_REPLAY_SIZE"); size != "" {
+		replaySize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal("invalid EVENTS_REPLAY_SIZE", err)
+		}
+	}
+	app.events = events.NewBus(replaySize)
+	feed := outbox.NewFeed(app.DB, app.events)
+	app.invalidation.Register(feed)
+
+	app.graph, err = graph.New(app.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.graph.Authorize = app.graphAuthorize
+	app.graph.OnMutation = app.wakeRelay
+	app.graph.Events = app.events
+	app.graph.Poster = app.GetMoviePoster
+	app.graph.Limits, err = graphLimitsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	persistedMaxEntries := 1000
+	if size := os.Getenv("GRAPHQL_PERSISTED_MAX_ENTRIES"); size != "" {
+		persistedMaxEntries, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal("invalid GRAPHQL_PERSISTED_MAX_ENTRIES", err)
+		}
+	}
+
+	app.graph.Persisted = graph.NewPersistedQueries(os.Getenv("GRAPHQL_ALLOWLIST") == "true", persistedMaxEntries)
+	app.graph.Persisted.Source = app.DB.PersistedQueries
+	app.invalidation.Register(app.graph.Persisted)
+	if err = app.graph.Persisted.Reload(); err != nil {
+		log.Fatal(err)
+	}
+	if dir := os.Getenv("GRAPHQL_PERSISTED_QUERIES_DIR"); dir != "" {
+		count, err := app.graph.LoadPersistedQueries(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("loaded %d persisted queries", count)
+	}
+
+	go invalidation.NewListener(app.DSN, app.invalidation).Run(context.Background())
+
 	app.auth = Auth{
 		Issuer:        app.JWTIssuer,
 		Audience:      app.JWTAudience,
@@ -70,6 +195,21 @@ func main() {
 		CookieName:    "refresh",
 	}
 
+	app.webhooks = webhooks.NewDispatcher(app.DB)
+	go app.webhooks.Run(context.Background())
+
+	app.relay = outbox.NewRelay(app.DB,
+		feed.Sink(),
+		outbox.Sink{Name: "webhooks", Deliver: app.webhooks.Enqueue},
+		outbox.Sink{Name: "log", Deliver: func(event events.Event) error {
+			log.Printf("event %d: %s %d", event.ID, event.Type, event.EntityID)
+			return nil
+		}},
+	)
+	go app.relay.Run(context.Background())
+
+	go app.purgeTrashPeriodically(time.Hour)
+
 	//start web server
 	log.Println("starting application on port:", port)
 
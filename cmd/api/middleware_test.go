@@ -0,0 +1,61 @@
+﻿package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConditionalGetWritesThroughWithETag(t *testing.T) {
+	app := &application{}
+	recorder := httptest.NewRecorder()
+	var flushedEarly bool
+	handler := app.conditionalGet("no-cache")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "[")
+		w.(http.Flusher).Flush()
+		flushedEarly = recorder.Body.String() == "["
+		io.WriteString(w, "]")
+	}))
+
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/movies", nil))
+	if !flushedEarly || !recorder.Flushed {
+		t.Error("response was buffered instead of written through")
+	}
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "[]" || recorder.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("got status %d, body %q, Cache-Control %q", recorder.Code, recorder.Body.String(), recorder.Header().Get("Cache-Control"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/movies", nil)
+	r.Header.Set("If-None-Match", `W/"v1"`)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("got status %d, body %q, Content-Type %q", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+}
+
+func TestConditionalGetHashesBufferedBody(t *testing.T) {
+	app := &application{}
+	handler := app.conditionalGet("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "genres")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/genres", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" || w.Body.String() != "genres" {
+		t.Fatalf("got status %d, ETag %q, body %q", w.Code, etag, w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/genres", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("got status %d, want 304", w.Code)
+	}
+}
@@ -0,0 +1,30 @@
+﻿package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (app *application) purgeTrashPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeTrash()
+		<-ticker.C
+	}
+}
+
+func (app *application) purgeTrash() {
+	ids, err := app.DB.PurgeDeletedMovies(context.Background(), time.Now().Add(-app.TrashRetention))
+	if err != nil {
+		log.Println("purge trash:", err)
+		return
+	}
+
+	if len(ids) > 0 {
+		app.wakeRelay()
+		log.Printf("purged %d movies from trash", len(ids))
+	}
+}
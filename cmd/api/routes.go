@@ -8,15 +8,27 @@ import (
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
+	mux.Use(middleware.RequestID)
 	mux.Use(middleware.Recoverer)
+	mux.Use(app.compress)
 	mux.Use(app.enableCORS)
 
 	mux.Get("/", app.Home)
-	mux.Get("/api/movies", app.Movies)
-	mux.Get("/api/movies/{id}", app.Movie)
+	mux.With(app.conditionalGet(app.CacheControl["/api/movies"])).Get("/api/movies", app.Movies)
+	mux.With(app.conditionalGet(app.CacheControl["/api/movies/{id}"])).Get("/api/movies/{id}", app.Movie)
 	mux.Get("/api/movies?genre={genre}", app.GetMoviesByGenre)
-	mux.Get("/api/genres", app.Genres)
+	mux.With(app.conditionalGet(app.CacheControl["/api/genres"])).Get("/api/genres", app.Genres)
+	mux.Get("/api/tags", app.Tags)
+	mux.Get("/api/collections", app.Collections)
+	mux.Get("/api/collections/{id}", app.Collection)
+	mux.Get("/api/graph", app.GraphQL)
 	mux.Post("/api/graph", app.GraphQL)
+	mux.Get("/api/graph/ws", app.GraphQLWebSocket)
+	mux.Get("/api/events", app.CatalogEvents)
+	if app.DevMode {
+		mux.Get("/api/graphiql", app.GraphiQL)
+		mux.Handle("/api/graphiql/assets/*", app.GraphiQLAssets())
+	}
 
 	mux.Get("/api/refresh", app.RefreshToken)
 	mux.Post("/api/authenticate", app.Authenticate)
@@ -28,7 +40,37 @@ func (app *application) routes() http.Handler {
 		adminMux.Post("/movies", app.PostCreateMovie)
 		adminMux.Get("/movies/{id}", app.CreateMovie)
 		adminMux.Put("/movies/{id}", app.PutUpdateMovie)
+		adminMux.Patch("/movies/{id}", app.PatchUpdateMovie)
 		adminMux.Delete("/movies/{id}", app.DeleteMovie)
+		adminMux.Put("/movies/{id}/tags", app.SetMovieTags)
+		adminMux.Post("/movies/{id}/relations", app.AddMovieRelation)
+		adminMux.Delete("/movies/{id}/relations/{type}/{related}", app.RemoveMovieRelation)
+		adminMux.Post("/collections", app.CreateCollection)
+		adminMux.Put("/collections/{id}", app.UpdateCollection)
+		adminMux.Delete("/collections/{id}", app.DeleteCollection)
+		adminMux.Get("/movies/{id}/revisions", app.MovieRevisions)
+		adminMux.Get("/movies/{id}/revisions/diff", app.MovieRevisionDiff)
+		adminMux.Get("/movies/{id}/revisions/{revision}", app.MovieRevision)
+		adminMux.Post("/movies/{id}/revisions/{revision}/revert", app.RevertMovie)
+		adminMux.Get("/genres", app.Genres)
+		adminMux.Post("/genres", app.CreateGenre)
+		adminMux.Put("/genres/{id}", app.UpdateGenre)
+		adminMux.Post("/genres/{id}/merge", app.MergeGenre)
+		adminMux.Delete("/genres/{id}", app.DeleteGenre)
+		adminMux.Get("/trash", app.Trash)
+		adminMux.Post("/trash/{id}/restore", app.RestoreMovie)
+		adminMux.Delete("/trash/{id}", app.PurgeMovie)
+		adminMux.Get("/audit", app.AuditLog)
+		adminMux.Get("/cache", app.CacheStats)
+		adminMux.Get("/graph/queries", app.PersistedQueries)
+		adminMux.Post("/graph/queries", app.RegisterPersistedQuery)
+		adminMux.Get("/webhooks", app.Webhooks)
+		adminMux.Post("/webhooks", app.CreateWebhook)
+		adminMux.Get("/webhooks/{id}", app.Webhook)
+		adminMux.Put("/webhooks/{id}", app.UpdateWebhook)
+		adminMux.Delete("/webhooks/{id}", app.DeleteWebhook)
+		adminMux.Get("/webhooks/{id}/deliveries", app.WebhookDeliveries)
+		adminMux.Post("/webhooks/{id}/deliveries/{delivery}/redeliver", app.RedeliverWebhook)
 	})
 	return mux
 }
@@ -0,0 +1,149 @@
+﻿package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"movie-library/internal/events"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sseKeepAlive = time.Second * 15
+
+// CatalogEvents streams catalog events as Server-Sent Events. Clients resume with the
+// Last-Event-ID header (or a lastEventId query parameter) and can limit the stream to movies
+// in, and changes to, particular genres with ?genre=1,2.
+func (app *application) CatalogEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.errorJSON(w, errors.New("streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+
+	genres, err := genreFilter(r.URL.Query().Get("genre"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	// subscribe before reading the replay buffer so no event falls between the two
+	ch, unsubscribe := app.events.Subscribe(64)
+	defer unsubscribe()
+
+	var replay []events.Event
+	complete := true
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			app.errorJSON(w, errors.New("invalid Last-Event-ID"))
+			return
+		}
+		replay, complete = app.events.Since(id)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if !complete {
+		// the client missed events that are no longer buffered and should reload its state
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+
+	var sent uint64
+	for _, event := range replay {
+		if matchesGenres(event, genres) {
+			writeSSE(w, event)
+		}
+		sent = event.ID
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if event.ID <= sent || !matchesGenres(event, genres) {
+				continue
+			}
+			writeSSE(w, event)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event events.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
+
+func genreFilter(value string) ([]int, error) {
+	var genres []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genre id %q", part)
+		}
+		genres = append(genres, id)
+	}
+
+	return genres, nil
+}
+
+// matchesGenres reports whether an event concerns one of the genres. Movie events match on
+// the genres of the movie snapshot carried by the event.
+func matchesGenres(event events.Event, genres []int) bool {
+	if len(genres) == 0 {
+		return true
+	}
+
+	if event.Genre != nil {
+		return slices.Contains(genres, event.Genre.ID)
+	}
+
+	if event.Movie != nil {
+		for _, genre := range event.Movie.Genres {
+			if slices.Contains(genres, genre.ID) {
+				return true
+			}
+		}
+		for _, id := range event.Movie.GenresArray {
+			if slices.Contains(genres, id) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
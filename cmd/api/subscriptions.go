@@ -0,0 +1,261 @@
+﻿package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"movie-library/internal/graph"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// graphql-transport-ws protocol, see https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const (
+	graphWSProtocol    = "graphql-transport-ws"
+	graphWSInitTimeout = time.Second * 10
+)
+
+type graphWSMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+var graphWSUpgrader = websocket.Upgrader{
+	Subprotocols: []string{graphWSProtocol},
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		return origin == "" || origin == corsOrigin || strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://") == r.Host
+	},
+}
+
+type graphWSConnection struct {
+	app           *application
+	conn          *websocket.Conn
+	writeMu       sync.Mutex
+	mu            sync.Mutex
+	ctx           context.Context
+	acknowledged  bool
+	subscriptions map[string]context.CancelFunc
+}
+
+func (app *application) GraphQLWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := graphWSUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("graphql websocket:", err)
+		return
+	}
+
+	c := &graphWSConnection{
+		app:           app,
+		conn:          conn,
+		subscriptions: make(map[string]context.CancelFunc),
+	}
+
+	if conn.Subprotocol() != graphWSProtocol {
+		c.close(4406, "Subprotocol not acceptable")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	c.ctx = ctx
+
+	c.serve(r)
+}
+
+func (c *graphWSConnection) serve(r *http.Request) {
+	defer c.conn.Close()
+
+	initTimer := time.AfterFunc(graphWSInitTimeout, func() {
+		c.mu.Lock()
+		acknowledged := c.acknowledged
+		c.mu.Unlock()
+		if !acknowledged {
+			c.close(4408, "Connection initialisation timeout")
+		}
+	})
+	defer initTimer.Stop()
+
+	var expiryTimer *time.Timer
+	defer func() {
+		if expiryTimer != nil {
+			expiryTimer.Stop()
+		}
+	}()
+
+	for {
+		var message graphWSMessage
+		err := c.conn.ReadJSON(&message)
+		if err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				c.close(4400, "Invalid message received")
+			}
+			return
+		}
+
+		switch message.Type {
+		case "connection_init":
+			c.mu.Lock()
+			if c.acknowledged {
+				c.mu.Unlock()
+				c.close(4429, "Too many initialisation requests")
+				return
+			}
+			c.mu.Unlock()
+
+			claims, err := c.authenticate(r, message.Payload)
+			if err != nil {
+				c.close(4403, "Forbidden")
+				return
+			}
+
+			// the token is only presented once, so the connection ends when it expires
+			if claims.ExpiresAt != nil {
+				expiryTimer = time.AfterFunc(time.Until(claims.ExpiresAt.Time), func() {
+					c.close(4403, "Forbidden")
+				})
+			}
+
+			c.mu.Lock()
+			c.ctx = withClaims(c.ctx, claims)
+			c.acknowledged = true
+			c.mu.Unlock()
+			c.write(graphWSMessage{Type: "connection_ack"})
+
+		case "ping":
+			c.write(graphWSMessage{Type: "pong", Payload: message.Payload})
+
+		case "pong":
+
+		case "subscribe":
+			c.mu.Lock()
+			acknowledged := c.acknowledged
+			_, exists := c.subscriptions[message.ID]
+			c.mu.Unlock()
+
+			if !acknowledged {
+				c.close(4401, "Unauthorized")
+				return
+			}
+			if message.ID == "" {
+				c.close(4400, "Invalid message received")
+				return
+			}
+			if exists {
+				c.close(4409, fmt.Sprintf("Subscriber for %s already exists", message.ID))
+				return
+			}
+
+			var request graph.Request
+			if err := json.Unmarshal(message.Payload, &request); err != nil {
+				c.close(4400, "Invalid message received")
+				return
+			}
+
+			c.start(message.ID, request)
+
+		case "complete":
+			c.stop(message.ID)
+
+		default:
+			c.close(4400, "Invalid message received")
+			return
+		}
+	}
+}
+
+// authenticate verifies the JWT sent in the connection_init payload, falling back to the
+// Authorization header of the upgrade request.
+func (c *graphWSConnection) authenticate(r *http.Request, payload json.RawMessage) (*Claims, error) {
+	var params map[string]interface{}
+	_ = json.Unmarshal(payload, &params)
+
+	header, _ := params["Authorization"].(string)
+	if header == "" {
+		header, _ = params["authorization"].(string)
+	}
+	if header == "" {
+		header = r.Header.Get("Authorization")
+	}
+
+	token := strings.TrimPrefix(header, "Bearer ")
+	return c.app.auth.VerifyToken(token)
+}
+
+func (c *graphWSConnection) start(id string, request graph.Request) {
+	c.mu.Lock()
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.subscriptions[id] = cancel
+	c.mu.Unlock()
+
+	go func() {
+		defer c.stop(id)
+
+		if c.app.graph.OperationType(request) != "subscription" {
+			resp := c.app.graph.Query(ctx, request)
+			c.next(id, resp)
+			c.write(graphWSMessage{ID: id, Type: "complete"})
+			return
+		}
+
+		first := true
+		for resp := range c.app.graph.Subscribe(ctx, request) {
+			if first && resp.Data == nil && len(resp.Errors) > 0 {
+				payload, _ := json.Marshal(resp.Errors)
+				c.write(graphWSMessage{ID: id, Type: "error", Payload: payload})
+				return
+			}
+			first = false
+			c.next(id, resp)
+		}
+
+		if ctx.Err() == nil {
+			c.write(graphWSMessage{ID: id, Type: "complete"})
+		}
+	}()
+}
+
+func (c *graphWSConnection) stop(id string) {
+	c.mu.Lock()
+	cancel, ok := c.subscriptions[id]
+	delete(c.subscriptions, id)
+	c.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+}
+
+func (c *graphWSConnection) next(id string, resp interface{}) {
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("graphql websocket:", err)
+		return
+	}
+
+	c.write(graphWSMessage{ID: id, Type: "next", Payload: payload})
+}
+
+func (c *graphWSConnection) write(message graphWSMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	if err := c.conn.WriteJSON(message); err != nil {
+		log.Println("graphql websocket:", err)
+	}
+}
+
+func (c *graphWSConnection) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	message := websocket.FormatCloseMessage(code, reason)
+	_ = c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	_ = c.conn.Close()
+}
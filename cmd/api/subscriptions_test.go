@@ -0,0 +1,47 @@
+﻿package main
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphQLWebSocketClosesWhenTokenExpires(t *testing.T) {
+	app := &application{auth: Auth{Issuer: "test", Audience: "test", Secret: "secret", TokenExpiry: time.Second * 2}}
+	tokens, err := app.auth.GenerateTokenPair(&jwtUser{ID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(app.GraphQLWebSocket))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{graphWSProtocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.WriteJSON(graphWSMessage{Type: "connection_init", Payload: []byte(`{"Authorization":"Bearer ` + tokens.Token + `"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ack graphWSMessage
+	if err = conn.ReadJSON(&ack); err != nil || ack.Type != "connection_ack" {
+		t.Fatalf("got %+v, err %v", ack, err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, _, err = conn.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != 4403 {
+		t.Fatalf("expected the connection to close with 4403 once the token expired, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+﻿package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"movie-library/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Tags autocompletes tag names. It returns the most used tags starting with the q parameter.
+func (app *application) Tags(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 100 {
+			app.errorJSON(w, errors.New("invalid limit"))
+			return
+		}
+	}
+
+	tags, err := app.DB.Tags(strings.TrimSpace(r.URL.Query().Get("q")), limit)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	streamJSON(w, http.StatusOK, tags)
+}
+
+func (app *application) SetMovieTags(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var payload struct {
+		Tags []string `json:"tags"`
+	}
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	tags, err := models.NormalizeTags(payload.Tags)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.SetMovieTags(r.Context(), id, tags)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("movie not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+	app.wakeRelay()
+
+	app.writeCommitted(w, http.StatusOK, "movie tags updated", func() (interface{}, error) {
+		return app.DB.GetMovieByID(id)
+	})
+}
+
+// tagsParam reads the comma separated tags parameter used to filter movies.
+func tagsParam(r *http.Request) []string {
+	var tags []string
+	for _, tag := range strings.Split(r.URL.Query().Get("tags"), ",") {
+		if tag = strings.Join(strings.Fields(tag), " "); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -1,10 +1,16 @@
 ﻿package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"log"
+	"movie-library/internal/models"
 	"net/http"
+	"strings"
 )
 
 type JSONResponse struct {
@@ -36,6 +42,47 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
+// streamJSON writes items as a JSON array, encoding one element at a time straight to w
+// instead of marshalling the whole list first. Once the first element is written the status
+// can no longer change, so an encoding error part way through leaves a truncated body.
+func streamJSON[T any](w http.ResponseWriter, status int, items []T, headers ...http.Header) error {
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// match json.Marshal, which encodes a nil slice as null
+	if items == nil {
+		_, err := io.WriteString(w, "null")
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for i, item := range items {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+
+		if err := enc.Encode(item); err != nil {
+			log.Println("stream json:", err)
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
 
 	maxBytes := 1024 * 1024 //1mb
@@ -70,3 +117,77 @@ func (app *application) errorJSON(w http.ResponseWriter, err error, status ...in
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// writeCommitted responds to a write that has already been committed with the resource load
+// reads back. A failed read cannot undo the write, so it is logged and the client is sent
+// message instead of an error.
+func (app *application) writeCommitted(w http.ResponseWriter, status int, message string, load func() (interface{}, error), headers ...http.Header) error {
+	data, err := load()
+	if err != nil {
+		log.Println("read after write:", err)
+		data = JSONResponse{Message: message}
+	}
+
+	return app.writeJSON(w, status, data, headers...)
+}
+
+func movieETag(movie *models.Movie) string {
+	return fmt.Sprintf(`"%d"`, movie.Version)
+}
+
+// moviesETag derives a list's ETag from the IDs and versions of its movies, which lets the
+// list be streamed without first buffering the body to hash it.
+func moviesETag(movies []*models.Movie) string {
+	hash := sha256.New()
+	for _, movie := range movies {
+		fmt.Fprintf(hash, "%d:%d,", movie.ID, movie.Version)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// strongETagMatch reports whether an If-Match header value matches etag using the strong
+// comparison of RFC 9110: weak tags never match.
+func strongETagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (candidate == etag && !strings.HasPrefix(etag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// weakETagMatch reports whether an If-None-Match header value matches etag using the weak
+// comparison of RFC 9110, which ignores the W/ prefix on either side.
+func weakETagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePatch applies an RFC 7396 JSON merge patch to target and returns the result.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
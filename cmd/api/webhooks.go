@@ -0,0 +1,280 @@
+﻿package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"movie-library/internal/events"
+	"movie-library/internal/models"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type webhookPayload struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+	Active     *bool    `json:"active"`
+}
+
+func (p webhookPayload) validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+
+	for _, eventType := range p.EventTypes {
+		if eventType == "*" || slices.Contains(events.Types, eventType) {
+			continue
+		}
+		if prefix, ok := strings.CutSuffix(eventType, "*"); ok && slices.ContainsFunc(events.Types, func(t string) bool {
+			return strings.HasPrefix(t, prefix)
+		}) {
+			continue
+		}
+		return fmt.Errorf("unknown event type %q", eventType)
+	}
+
+	return nil
+}
+
+func webhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// withoutSecret returns a copy of webhook that is safe to list or log. The secret is only
+// returned when a webhook is created.
+func withoutSecret(webhook *models.Webhook) *models.Webhook {
+	copied := *webhook
+	copied.Secret = ""
+	return &copied
+}
+
+func (app *application) Webhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.DB.AllWebhooks()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	for i, webhook := range webhooks {
+		webhooks[i] = withoutSecret(webhook)
+	}
+
+	app.writeJSON(w, http.StatusOK, webhooks)
+}
+
+func (app *application) Webhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, withoutSecret(webhook))
+}
+
+func (app *application) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload webhookPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if err = payload.validate(); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	webhook := models.Webhook{
+		URL:        payload.URL,
+		EventTypes: payload.EventTypes,
+		Secret:     payload.Secret,
+		Active:     payload.Active == nil || *payload.Active,
+	}
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	if webhook.Secret == "" {
+		webhook.Secret, err = webhookSecret()
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	webhook.ID, err = app.DB.InsertWebhook(r.Context(), webhook)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	created, err := app.DB.GetWebhook(webhook.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, created)
+}
+
+func (app *application) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	before, ok := app.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var payload webhookPayload
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if err = payload.validate(); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	webhook := *before
+	webhook.URL = payload.URL
+	webhook.EventTypes = payload.EventTypes
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	if payload.Secret != "" {
+		webhook.Secret = payload.Secret
+	}
+	if payload.Active != nil {
+		webhook.Active = *payload.Active
+	}
+
+	err = app.DB.UpdateWebhook(r.Context(), webhook)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	after, err := app.DB.GetWebhook(webhook.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, withoutSecret(after))
+}
+
+func (app *application) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.DB.DeleteWebhook(r.Context(), webhook.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "webhook deleted",
+	}
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset := 50, 0
+	var err error
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 500 {
+			app.errorJSON(w, errors.New("invalid limit"))
+			return
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			app.errorJSON(w, errors.New("invalid offset"))
+			return
+		}
+	}
+
+	deliveries, err := app.DB.WebhookDeliveries(webhook.ID, limit, offset)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, deliveries)
+}
+
+func (app *application) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := app.webhookFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(chi.URLParam(r, "delivery"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	original, err := app.DB.GetWebhookDelivery(webhook.ID, deliveryID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("delivery not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(w, err)
+		return
+	}
+
+	delivery, err := app.webhooks.Redeliver(webhook, original)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusAccepted, delivery)
+}
+
+func (app *application) webhookFromURL(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	webhook, err := app.DB.GetWebhook(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("webhook not found"), http.StatusNotFound)
+			return nil, false
+		}
+		app.errorJSON(w, err)
+		return nil, false
+	}
+
+	return webhook, true
+}
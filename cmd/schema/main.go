@@ -0,0 +1,17 @@
+﻿package main
+
+import (
+	"fmt"
+	"log"
+	"movie-library/internal/graph"
+)
+
+// schema prints the GraphQL schema in SDL, e.g. go run ./cmd/schema > internal/graph/schema.graphql
+func main() {
+	g, err := graph.New(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Print(g.SDL())
+}
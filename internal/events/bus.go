@@ -0,0 +1,154 @@
+﻿package events
+
+import (
+	"movie-library/internal/models"
+	"sync"
+	"time"
+)
+
+const (
+	MovieCreated  = "movie.created"
+	MovieUpdated  = "movie.updated"
+	MovieDeleted  = "movie.deleted"
+	MovieRestored = "movie.restored"
+	GenreCreated  = "genre.created"
+	GenreUpdated  = "genre.updated"
+	GenreDeleted  = "genre.deleted"
+
+	CollectionCreated = "collection.created"
+	CollectionUpdated = "collection.updated"
+	CollectionDeleted = "collection.deleted"
+)
+
+// Types lists every event type the bus publishes.
+var Types = []string{
+	MovieCreated, MovieUpdated, MovieDeleted, MovieRestored,
+	GenreCreated, GenreUpdated, GenreDeleted,
+	CollectionCreated, CollectionUpdated, CollectionDeleted,
+}
+
+type Event struct {
+	ID         uint64             `json:"id"`
+	Type       string             `json:"type"`
+	EntityID   int                `json:"entity_id"`
+	Movie      *models.Movie      `json:"movie,omitempty"`
+	Genre      *models.Genre      `json:"genre,omitempty"`
+	Collection *models.Collection `json:"collection,omitempty"`
+	OccurredAt time.Time          `json:"occurred_at"`
+}
+
+// Bus fans catalog events out to in-process subscribers. Publishing never blocks: a subscriber
+// whose buffer is full misses the event. The most recent events are kept in a bounded replay
+// buffer so clients that reconnect can catch up.
+type Bus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	nextSub     int
+	subscribers map[int]chan Event
+	replay      []Event
+	replaySize  int
+	// horizon is the highest event ID that is no longer, or never was, in the replay buffer.
+	horizon   uint64
+	published bool
+}
+
+func NewBus(replaySize int) *Bus {
+	return &Bus{
+		subscribers: make(map[int]chan Event),
+		replaySize:  replaySize,
+	}
+}
+
+// Publish sends event to every subscriber. Events that already carry an ID, such as those
+// relayed from the outbox, keep it; others are numbered after the last event published.
+func (b *Bus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if event.ID == 0 {
+		event.ID = b.lastID + 1
+	}
+	if !b.published {
+		// events before the first one this bus saw were published by an earlier process
+		b.horizon = event.ID - 1
+		b.published = true
+	}
+	if event.ID > b.lastID {
+		b.lastID = event.ID
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now().UTC()
+	}
+
+	if b.replaySize > 0 {
+		if len(b.replay) == b.replaySize {
+			b.horizon = max(b.horizon, b.replay[0].ID)
+			copy(b.replay, b.replay[1:])
+			b.replay = b.replay[:len(b.replay)-1]
+		}
+		b.replay = append(b.replay, event)
+	} else {
+		b.horizon = b.lastID
+	}
+
+	for _, ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// MarkGap records that events published elsewhere may have been missed, so clients resuming
+// from an event published before now are told to reload their state.
+func (b *Bus) MarkGap() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.horizon = b.lastID
+}
+
+// Subscribe returns a channel of events published from now on and a function that
+// unsubscribes and closes the channel.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextSub
+	b.nextSub++
+	ch := make(chan Event, buffer)
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, id)
+			close(ch)
+			b.mu.Unlock()
+		})
+	}
+}
+
+// Since returns the buffered events published after lastID. It reports false when events
+// after lastID are no longer buffered, or lastID was never issued by this bus, in which case
+// the client has missed events and should reload its state.
+func (b *Bus) Since(lastID uint64) ([]Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastID > b.lastID {
+		return nil, false
+	}
+
+	complete := lastID >= b.horizon
+
+	var events []Event
+	for _, event := range b.replay {
+		if event.ID > lastID {
+			events = append(events, event)
+		}
+	}
+
+	return events, complete
+}
@@ -0,0 +1,357 @@
+﻿package graph
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/graphql-go/graphql"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"strings"
+)
+
+// addCollectionTypes defines collections and movie relations and links them to movies.
+func (g *Graph) addCollectionTypes() {
+	g.relationTypeEnum = graphql.NewEnum(
+		graphql.EnumConfig{
+			Name: "RelationType",
+			Values: graphql.EnumValueConfigMap{
+				"SEQUEL_OF": &graphql.EnumValueConfig{Value: models.RelationSequelOf},
+				"REMAKE_OF": &graphql.EnumValueConfig{Value: models.RelationRemakeOf},
+				"SPIN_OFF":  &graphql.EnumValueConfig{Value: models.RelationSpinOff},
+			},
+		},
+	)
+
+	g.collectionType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Collection",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"name": &graphql.Field{
+					Type: graphql.String,
+				},
+				"description": &graphql.Field{
+					Type: graphql.String,
+				},
+				"image": &graphql.Field{
+					Type: graphql.String,
+				},
+				"movies": &graphql.Field{
+					Type:        graphql.NewList(g.movieType),
+					Description: "Movies in the collection, in order",
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						collection, ok := params.Source.(*models.Collection)
+						if !ok {
+							return nil, nil
+						}
+
+						if collection.Movies != nil {
+							return collection.Movies, nil
+						}
+
+						return g.loaders(params.Context).collectionMovies.load(collection.ID), nil
+					},
+				},
+			},
+		},
+	)
+
+	collectionEntryType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name:        "CollectionEntry",
+			Description: "A movie's place in one of its collections",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "ID of the collection",
+				},
+				"name": &graphql.Field{
+					Type: graphql.String,
+				},
+				"position": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"previous": &graphql.Field{
+					Type: g.movieType,
+				},
+				"next": &graphql.Field{
+					Type: g.movieType,
+				},
+			},
+		},
+	)
+
+	movieRelationType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "MovieRelation",
+			Fields: graphql.Fields{
+				"type": &graphql.Field{
+					Type: g.relationTypeEnum,
+				},
+				"inverse": &graphql.Field{
+					Type:        graphql.Boolean,
+					Description: "True when the related movie is the sequel, remake or spin-off of this one",
+				},
+				"movie": &graphql.Field{
+					Type: g.movieType,
+				},
+			},
+		},
+	)
+
+	g.movieType.AddFieldConfig("collections", &graphql.Field{
+		Type:        graphql.NewList(collectionEntryType),
+		Description: "The collections the movie belongs to, with its neighbours in each",
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			movie, ok := params.Source.(*models.Movie)
+			if !ok {
+				return nil, nil
+			}
+
+			return g.loaders(params.Context).movieCollections.load(movie.ID), nil
+		},
+	})
+
+	g.movieType.AddFieldConfig("relations", &graphql.Field{
+		Type:        graphql.NewList(movieRelationType),
+		Description: "Sequels, remakes and spin-offs of the movie and the movies it derives from",
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			movie, ok := params.Source.(*models.Movie)
+			if !ok {
+				return nil, nil
+			}
+
+			return g.loaders(params.Context).movieRelations.load(movie.ID), nil
+		},
+	})
+}
+
+func (g *Graph) collectionQueryFields() graphql.Fields {
+	return graphql.Fields{
+		"collections": &graphql.Field{
+			Type:        graphql.NewList(g.collectionType),
+			Description: "Get all collections",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return g.DB.Collections()
+			},
+		},
+		"collection": &graphql.Field{
+			Type:        g.collectionType,
+			Description: "Get collection by id",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				id, _ := params.Args["id"].(int)
+				collection, err := g.DB.GetCollection(id)
+				if err != nil {
+					return nil, errors.New("collection not found")
+				}
+
+				return collection, nil
+			},
+		},
+	}
+}
+
+func (g *Graph) collectionMutationFields() graphql.Fields {
+	collectionInputType := graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "CollectionInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"name": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"description": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"image": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"movie_ids": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(graphql.Int)),
+					Description: "The collection's movies in order; omit to keep them when updating",
+				},
+			},
+		},
+	)
+
+	relationArgs := graphql.FieldConfigArgument{
+		"movieId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"relatedMovieId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.Int),
+		},
+		"type": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(g.relationTypeEnum),
+		},
+	}
+
+	return graphql.Fields{
+		"createCollection": &graphql.Field{
+			Type:        g.collectionType,
+			Description: "Create a collection",
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(collectionInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				collection, err := collectionFromInput(params.Args["input"])
+				if err != nil {
+					return nil, err
+				}
+
+				id, err := g.DB.InsertCollection(params.Context, collection)
+				if err != nil {
+					return nil, err
+				}
+
+				g.mutated()
+
+				return committed(func() (interface{}, error) {
+					return g.DB.GetCollection(id)
+				})
+			},
+		},
+		"updateCollection": &graphql.Field{
+			Type:        g.collectionType,
+			Description: "Replace a collection's details and, when listed, its movies",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(collectionInputType),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				collection, err := collectionFromInput(params.Args["input"])
+				if err != nil {
+					return nil, err
+				}
+
+				collection.ID = id
+				err = g.DB.UpdateCollection(params.Context, collection)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("collection not found")
+				}
+				if err != nil {
+					return nil, err
+				}
+				g.mutated()
+
+				return committed(func() (interface{}, error) {
+					return g.DB.GetCollection(id)
+				})
+			},
+		},
+		"deleteCollection": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Delete a collection; its movies are kept",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				err := g.DB.DeleteCollection(params.Context, id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("collection not found")
+				}
+				if err != nil {
+					return nil, err
+				}
+				g.mutated()
+
+				return true, nil
+			},
+		},
+		"addMovieRelation": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Record that a movie is a sequel, remake or spin-off of another",
+			Args:        relationArgs,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return g.changeRelation(params, true)
+			},
+		},
+		"removeMovieRelation": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Remove a relation between two movies",
+			Args:        relationArgs,
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return g.changeRelation(params, false)
+			},
+		},
+	}
+}
+
+func (g *Graph) changeRelation(params graphql.ResolveParams, add bool) (interface{}, error) {
+	if err := g.authorize(params.Context); err != nil {
+		return nil, err
+	}
+
+	movieID, _ := params.Args["movieId"].(int)
+	relatedID, _ := params.Args["relatedMovieId"].(int)
+	relationType, _ := params.Args["type"].(string)
+	if movieID == relatedID {
+		return nil, repository.ErrSelfRelation
+	}
+
+	var err error
+	if add {
+		err = g.DB.AddMovieRelation(params.Context, movieID, relatedID, relationType)
+	} else {
+		err = g.DB.RemoveMovieRelation(params.Context, movieID, relatedID, relationType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	g.mutated()
+
+	return committed(func() (interface{}, error) {
+		return g.DB.GetMovieByID(movieID)
+	})
+}
+
+func collectionFromInput(value interface{}) (models.Collection, error) {
+	var collection models.Collection
+	input, ok := value.(map[string]interface{})
+	if !ok {
+		return collection, errors.New("input is required")
+	}
+
+	collection.Name, _ = input["name"].(string)
+	collection.Name = strings.TrimSpace(collection.Name)
+	if collection.Name == "" {
+		return collection, errors.New("name is required")
+	}
+
+	collection.Description, _ = input["description"].(string)
+	collection.Image, _ = input["image"].(string)
+	if _, ok := input["movie_ids"]; ok {
+		collection.MovieIDs = intList(input["movie_ids"])
+	}
+
+	return collection, nil
+}
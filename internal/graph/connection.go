@@ -0,0 +1,301 @@
+﻿package graph
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"movie-library/internal/models"
+	"strconv"
+	"time"
+)
+
+const defaultPageSize = 20
+
+func (g *Graph) moviesConnectionField() *graphql.Field {
+	pageInfoType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "PageInfo",
+			Fields: graphql.Fields{
+				"hasNextPage": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+				"hasPreviousPage": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.Boolean),
+				},
+				"startCursor": &graphql.Field{
+					Type: graphql.String,
+				},
+				"endCursor": &graphql.Field{
+					Type: graphql.String,
+				},
+			},
+		},
+	)
+
+	movieEdgeType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "MovieEdge",
+			Fields: graphql.Fields{
+				"node": &graphql.Field{
+					Type: g.movieType,
+				},
+				"cursor": &graphql.Field{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+			},
+		},
+	)
+
+	movieConnectionType := graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "MovieConnection",
+			Fields: graphql.Fields{
+				"edges": &graphql.Field{
+					Type: graphql.NewList(movieEdgeType),
+				},
+				"pageInfo": &graphql.Field{
+					Type: graphql.NewNonNull(pageInfoType),
+				},
+			},
+		},
+	)
+
+	movieSortFieldType := graphql.NewEnum(
+		graphql.EnumConfig{
+			Name: "MovieSortField",
+			Values: graphql.EnumValueConfigMap{
+				"TITLE":        &graphql.EnumValueConfig{Value: "title"},
+				"RELEASE_DATE": &graphql.EnumValueConfig{Value: "release_date"},
+				"CREATED_AT":   &graphql.EnumValueConfig{Value: "created_at"},
+				"RUN_TIME":     &graphql.EnumValueConfig{Value: "run_time"},
+			},
+		},
+	)
+
+	sortDirectionType := graphql.NewEnum(
+		graphql.EnumConfig{
+			Name: "SortDirection",
+			Values: graphql.EnumValueConfigMap{
+				"ASC":  &graphql.EnumValueConfig{Value: "asc"},
+				"DESC": &graphql.EnumValueConfig{Value: "desc"},
+			},
+		},
+	)
+
+	movieSortType := graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "MovieSort",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"field": &graphql.InputObjectFieldConfig{
+					Type:         movieSortFieldType,
+					DefaultValue: "title",
+				},
+				"direction": &graphql.InputObjectFieldConfig{
+					Type:         sortDirectionType,
+					DefaultValue: "asc",
+				},
+			},
+		},
+	)
+
+	movieFilterType := graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "MovieFilter",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"titleContains": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"genreId": &graphql.InputObjectFieldConfig{
+					Type:        graphql.Int,
+					Description: "Movies in the genre or any of its subgenres",
+				},
+				"tags": &graphql.InputObjectFieldConfig{
+					Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+					Description: "Movies with every listed tag",
+				},
+				"mpaaRating": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"releasedAfter": &graphql.InputObjectFieldConfig{
+					Type: graphql.DateTime,
+				},
+				"releasedBefore": &graphql.InputObjectFieldConfig{
+					Type: graphql.DateTime,
+				},
+			},
+		},
+	)
+
+	return &graphql.Field{
+		Type:        graphql.NewNonNull(movieConnectionType),
+		Description: "Page through movies with Relay cursor pagination",
+		Args: graphql.FieldConfigArgument{
+			"first": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"after": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"last": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+			"before": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"sort": &graphql.ArgumentConfig{
+				Type: movieSortType,
+			},
+			"filter": &graphql.ArgumentConfig{
+				Type: movieFilterType,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			page, size, err := moviePageQuery(params.Args)
+			if err != nil {
+				return nil, err
+			}
+
+			movies, err := g.DB.MoviesPage(page)
+			if err != nil {
+				return nil, err
+			}
+
+			hasMore := len(movies) > size
+			if hasMore {
+				if page.Backward {
+					movies = movies[len(movies)-size:]
+				} else {
+					movies = movies[:size]
+				}
+			}
+
+			edges := make([]map[string]interface{}, 0, len(movies))
+			for _, movie := range movies {
+				edges = append(edges, map[string]interface{}{
+					"node":   movie,
+					"cursor": encodeCursor(movieCursor(movie, page.SortField)),
+				})
+			}
+
+			pageInfo := map[string]interface{}{
+				"hasNextPage":     hasMore,
+				"hasPreviousPage": page.After != nil,
+				"startCursor":     nil,
+				"endCursor":       nil,
+			}
+			if page.Backward {
+				pageInfo["hasNextPage"] = page.Before != nil
+				pageInfo["hasPreviousPage"] = hasMore
+			}
+			if len(edges) > 0 {
+				pageInfo["startCursor"] = edges[0]["cursor"]
+				pageInfo["endCursor"] = edges[len(edges)-1]["cursor"]
+			}
+
+			return map[string]interface{}{
+				"edges":    edges,
+				"pageInfo": pageInfo,
+			}, nil
+		},
+	}
+}
+
+// moviePageQuery converts connection arguments into a repository query. The query asks for
+// one more movie than the page size so the resolver can tell whether another page exists.
+func moviePageQuery(args map[string]interface{}) (models.MoviePageQuery, int, error) {
+	var page models.MoviePageQuery
+
+	first, hasFirst := args["first"].(int)
+	last, hasLast := args["last"].(int)
+	if hasFirst && hasLast {
+		return page, 0, errors.New("first and last cannot be used together")
+	}
+
+	size := defaultPageSize
+	if hasFirst {
+		size = first
+	}
+	if hasLast {
+		size = last
+		page.Backward = true
+	}
+	if size < 1 || size > maxPageSize {
+		return page, 0, fmt.Errorf("page size must be between 1 and %d", maxPageSize)
+	}
+	page.Limit = size + 1
+
+	page.SortField = "title"
+	if sort, ok := args["sort"].(map[string]interface{}); ok {
+		if field, ok := sort["field"].(string); ok {
+			page.SortField = field
+		}
+		page.Descending = sort["direction"] == "desc"
+	}
+
+	var err error
+	if after, ok := args["after"].(string); ok {
+		page.After, err = decodeCursor(after, page.SortField)
+		if err != nil {
+			return page, 0, err
+		}
+	}
+	if before, ok := args["before"].(string); ok {
+		page.Before, err = decodeCursor(before, page.SortField)
+		if err != nil {
+			return page, 0, err
+		}
+	}
+
+	if filter, ok := args["filter"].(map[string]interface{}); ok {
+		page.TitleContains, _ = filter["titleContains"].(string)
+		page.GenreID, _ = filter["genreId"].(int)
+		page.Tags = stringList(filter["tags"])
+		page.MPAARating, _ = filter["mpaaRating"].(string)
+		page.ReleasedAfter, _ = filter["releasedAfter"].(time.Time)
+		page.ReleasedBefore, _ = filter["releasedBefore"].(time.Time)
+	}
+
+	return page, size, nil
+}
+
+func movieCursor(movie *models.Movie, sortField string) models.MovieCursor {
+	cursor := models.MovieCursor{SortField: sortField, ID: movie.ID}
+
+	switch sortField {
+	case "release_date":
+		cursor.Value = movie.ReleaseDate.UTC().Format(time.RFC3339Nano)
+	case "created_at":
+		cursor.Value = movie.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "run_time":
+		cursor.Value = strconv.Itoa(movie.RunTime)
+	default:
+		cursor.Value = movie.Title
+	}
+
+	return cursor
+}
+
+func encodeCursor(cursor models.MovieCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value, sortField string) (*models.MovieCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var cursor models.MovieCursor
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	if cursor.SortField != sortField {
+		return nil, errors.New("cursor does not match the requested sort field")
+	}
+
+	return &cursor, nil
+}
@@ -1,22 +1,39 @@
 ﻿package graph
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/graphql-go/graphql"
+	"movie-library/internal/events"
 	"movie-library/internal/models"
+	"movie-library/internal/repository"
 	"strings"
 )
 
+const maxPageSize = 100
+
 type Graph struct {
-	Movies      []*models.Movie
-	QueryString string
-	Config      graphql.SchemaConfig
-	fields      graphql.Fields
-	movieType   *graphql.Object
+	DB         repository.DatabaseRepo
+	Authorize  func(ctx context.Context) error
+	OnMutation MutationHook
+	Poster     func(movie models.Movie) models.Movie
+	Limits     Limits
+	Persisted  *PersistedQueries
+	Events     *events.Bus
+	schema     graphql.Schema
+	movieType  *graphql.Object
+	genreType  *graphql.Object
+	tagType    *graphql.Object
+
+	collectionType   *graphql.Object
+	relationTypeEnum *graphql.Enum
 }
 
-func New(movies []*models.Movie) *Graph {
-	var movieType = graphql.NewObject(
+func New(db repository.DatabaseRepo) (*Graph, error) {
+	g := &Graph{DB: db, Limits: DefaultLimits}
+
+	g.movieType = graphql.NewObject(
 		graphql.ObjectConfig{
 			Name: "Movie",
 			Fields: graphql.Fields{
@@ -41,6 +58,15 @@ func New(movies []*models.Movie) *Graph {
 				"image": &graphql.Field{
 					Type: graphql.String,
 				},
+				"imdb_id": &graphql.Field{
+					Type: graphql.String,
+				},
+				"tmdb_id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"wikidata_id": &graphql.Field{
+					Type: graphql.String,
+				},
 				"created_at": &graphql.Field{
 					Type: graphql.DateTime,
 				},
@@ -51,37 +77,215 @@ func New(movies []*models.Movie) *Graph {
 		},
 	)
 
+	g.genreType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Genre",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"genre": &graphql.Field{
+					Type: graphql.String,
+				},
+				"parent_id": &graphql.Field{
+					Type: graphql.Int,
+				},
+			},
+		},
+	)
+
+	g.tagType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Tag",
+			Fields: graphql.Fields{
+				"id": &graphql.Field{
+					Type: graphql.Int,
+				},
+				"name": &graphql.Field{
+					Type: graphql.String,
+				},
+				"movie_count": &graphql.Field{
+					Type:        graphql.Int,
+					Description: "Number of movies with the tag; only set by the tags query",
+				},
+			},
+		},
+	)
+
+	g.movieType.AddFieldConfig("genres", &graphql.Field{
+		Type:        graphql.NewList(g.genreType),
+		Description: "Genres of the movie",
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			movie, ok := params.Source.(*models.Movie)
+			if !ok {
+				return nil, nil
+			}
+
+			if movie.Genres != nil {
+				return movie.Genres, nil
+			}
+
+			return g.loaders(params.Context).movieGenres.load(movie.ID), nil
+		},
+	})
+
+	g.movieType.AddFieldConfig("tags", &graphql.Field{
+		Type:        graphql.NewList(g.tagType),
+		Description: "Tags of the movie, ordered by name",
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			movie, ok := params.Source.(*models.Movie)
+			if !ok {
+				return nil, nil
+			}
+
+			if movie.Tags != nil {
+				return movie.Tags, nil
+			}
+
+			return g.loaders(params.Context).movieTags.load(movie.ID), nil
+		},
+	})
+
+	g.genreType.AddFieldConfig("parent", &graphql.Field{
+		Type:        g.genreType,
+		Description: "The genre this one is a subgenre of",
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			genre, ok := params.Source.(*models.Genre)
+			if !ok || genre.ParentID == nil {
+				return nil, nil
+			}
+
+			genres, err := g.loaders(params.Context).allGenres()
+			if err != nil {
+				return nil, err
+			}
+
+			for _, candidate := range genres {
+				if candidate.ID == *genre.ParentID {
+					return candidate, nil
+				}
+			}
+			return nil, nil
+		},
+	})
+
+	g.genreType.AddFieldConfig("subgenres", &graphql.Field{
+		Type:        graphql.NewList(g.genreType),
+		Description: "Genres directly nested in the genre",
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			genre, ok := params.Source.(*models.Genre)
+			if !ok {
+				return nil, nil
+			}
+
+			genres, err := g.loaders(params.Context).allGenres()
+			if err != nil {
+				return nil, err
+			}
+
+			subgenres := []*models.Genre{}
+			for _, candidate := range genres {
+				if candidate.ParentID != nil && *candidate.ParentID == genre.ID {
+					subgenres = append(subgenres, candidate)
+				}
+			}
+			return subgenres, nil
+		},
+	})
+
+	g.genreType.AddFieldConfig("movies", &graphql.Field{
+		Type:        graphql.NewList(g.movieType),
+		Description: "Movies in the genre and its subgenres, ordered by title",
+		Args: graphql.FieldConfigArgument{
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 20,
+			},
+			"offset": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 0,
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+			genre, ok := params.Source.(*models.Genre)
+			if !ok {
+				return nil, nil
+			}
+
+			limit, _ := params.Args["limit"].(int)
+			offset, _ := params.Args["offset"].(int)
+			if limit <= 0 || limit > maxPageSize {
+				return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+			}
+			if offset < 0 {
+				return nil, errors.New("offset cannot be negative")
+			}
+
+			return g.loaders(params.Context).moviesInGenre(limit, offset).load(genre.ID), nil
+		},
+	})
+
+	g.addCollectionTypes()
+
 	var fields = graphql.Fields{
+		"genres": &graphql.Field{
+			Type:        graphql.NewList(g.genreType),
+			Description: "Get all genres",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return g.DB.Genres()
+			},
+		},
+		"tags": &graphql.Field{
+			Type:        graphql.NewList(g.tagType),
+			Description: "Autocomplete tags by prefix, most used first",
+			Args: graphql.FieldConfigArgument{
+				"prefix": &graphql.ArgumentConfig{
+					Type:         graphql.String,
+					DefaultValue: "",
+				},
+				"limit": &graphql.ArgumentConfig{
+					Type:         graphql.Int,
+					DefaultValue: 10,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				prefix, _ := params.Args["prefix"].(string)
+				limit, _ := params.Args["limit"].(int)
+				if limit <= 0 || limit > maxPageSize {
+					return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+				}
+
+				return g.DB.Tags(strings.TrimSpace(prefix), limit)
+			},
+		},
 		"list": &graphql.Field{
-			Type:        graphql.NewList(movieType),
-			Description: "Get all movies",
-			Resolve:     func(params graphql.ResolveParams) (interface{}, error) { return movies, nil },
+			Type:              graphql.NewList(g.movieType),
+			Description:       "Get all movies",
+			DeprecationReason: "Use movies, which is paginated",
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				return g.DB.AllMovies()
+			},
 		},
 		"search": &graphql.Field{
-			Type:        graphql.NewList(movieType),
-			Description: "Search by title",
+			Type:              graphql.NewList(g.movieType),
+			Description:       "Search by title",
+			DeprecationReason: "Use movies with filter.titleContains",
 			Args: graphql.FieldConfigArgument{
 				"titleContains": &graphql.ArgumentConfig{
 					Type: graphql.String,
 				},
 			},
 			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
-				var movieList []*models.Movie
 				search, ok := params.Args["titleContains"].(string)
-
-				if ok {
-					for _, movie := range movies {
-						if strings.Contains(strings.ToLower(movie.Title), strings.ToLower(search)) {
-							movieList = append(movieList, movie)
-						}
-					}
+				if !ok {
+					return nil, nil
 				}
 
-				return movieList, nil
+				return g.DB.SearchMovies(search)
 			},
 		},
 		"get": &graphql.Field{
-			Type:        movieType,
+			Type:        g.movieType,
 			Description: "Get movie by id",
 			Args: graphql.FieldConfigArgument{
 				"id": &graphql.ArgumentConfig{
@@ -90,44 +294,41 @@ func New(movies []*models.Movie) *Graph {
 			},
 			Resolve: func(param graphql.ResolveParams) (interface{}, error) {
 				id, ok := param.Args["id"].(int)
-				if ok {
-					for _, movie := range movies {
-						if movie.ID == id {
-							return movie, nil
-						}
-					}
+				if !ok {
+					return nil, nil
 				}
-				return nil, nil
+
+				return g.loaders(param.Context).movies.load(id), nil
 			},
 		},
 	}
 
-	return &Graph{
-		fields:    fields,
-		movieType: movieType,
-		Movies:    movies,
+	fields["movies"] = g.moviesConnectionField()
+	for name, field := range g.collectionQueryFields() {
+		fields[name] = field
 	}
-}
 
-func (g *Graph) Query() (*graphql.Result, error) {
 	rootQuery := graphql.ObjectConfig{
 		Name:   "RootQuery",
-		Fields: g.fields,
+		Fields: fields,
 	}
 	schemaConfig := graphql.SchemaConfig{
 		Query: graphql.NewObject(rootQuery),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "RootMutation",
+			Fields: g.mutationFields(),
+		}),
+		Subscription: graphql.NewObject(graphql.ObjectConfig{
+			Name:   "RootSubscription",
+			Fields: g.subscriptionFields(),
+		}),
 	}
-	schema, err := graphql.NewSchema(schemaConfig)
+
+	var err error
+	g.schema, err = graphql.NewSchema(schemaConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	params := graphql.Params{Schema: schema, RequestString: g.QueryString}
-	resp := graphql.Do(params)
-
-	if len(resp.Errors) > 0 {
-		return nil, errors.New("Bad query")
-	}
-
-	return resp, nil
+	return g, nil
 }
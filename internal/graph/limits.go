@@ -0,0 +1,270 @@
+﻿package graph
+
+import (
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits bounds the cost of a single GraphQL request. A zero value disables the corresponding check.
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+	// Timeout bounds the execution of queries. Mutations are not cut off.
+	Timeout time.Duration
+	// FieldCosts holds the cost of resolving a field, keyed by "Type.field". Fields not listed cost 1.
+	FieldCosts map[string]int
+	// ListSizes overrides DefaultListSize for individual list fields, keyed by "Type.field".
+	ListSizes map[string]int
+	// DefaultListSize is the multiplier used for list fields that have no paging argument.
+	DefaultListSize int
+}
+
+var DefaultLimits = Limits{
+	MaxDepth:      8,
+	MaxComplexity: 2000,
+	Timeout:       time.Second * 5,
+	FieldCosts: map[string]int{
+		"RootQuery.list":        10,
+		"RootQuery.search":      5,
+		"RootQuery.genres":      2,
+		"Movie.genres":          2,
+		"Genre.movies":          2,
+		"Movie.tags":            2,
+		"RootQuery.tags":        2,
+		"Movie.collection":      2,
+		"Movie.relations":       2,
+		"Collection.movies":     2,
+		"RootQuery.collections": 2,
+		"RootQuery.movies":      2,
+	},
+	ListSizes: map[string]int{
+		// Connections are multiplied by their first or last argument, or by the default page
+		// size, on the connection field itself.
+		"MovieConnection.edges": 1,
+	},
+	DefaultListSize: 10,
+}
+
+// ParseFieldCosts parses a comma separated list of Type.field=cost pairs.
+func ParseFieldCosts(value string) (map[string]int, error) {
+	costs := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		field, cost, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid field cost %q", pair)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(cost))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid field cost %q", pair)
+		}
+		costs[strings.TrimSpace(field)] = n
+	}
+
+	return costs, nil
+}
+
+// listSizeArguments are the arguments whose value bounds the length of a list field.
+var listSizeArguments = []string{"first", "last", "limit"}
+
+type costAnalysis struct {
+	schema    *graphql.Schema
+	limits    Limits
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// checkLimits computes the depth and complexity of the selected operation and returns an
+// error for the first limit it exceeds.
+func (g *Graph) checkLimits(document *ast.Document, request Request) *gqlerrors.FormattedError {
+	operation := findOperation(document, request.OperationName)
+	if operation == nil {
+		return nil
+	}
+
+	analysis := costAnalysis{
+		schema:    &g.schema,
+		limits:    g.Limits,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: request.Variables,
+	}
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			analysis.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	var root *graphql.Object
+	switch operation.Operation {
+	case ast.OperationTypeMutation:
+		root = g.schema.MutationType()
+	case ast.OperationTypeSubscription:
+		root = g.schema.SubscriptionType()
+	default:
+		root = g.schema.QueryType()
+	}
+
+	depth, complexity := analysis.selectionSet(root, operation.SelectionSet, 1)
+
+	if g.Limits.MaxDepth > 0 && depth > g.Limits.MaxDepth {
+		return limitError(operation, "QUERY_TOO_DEEP", fmt.Sprintf("query depth %d exceeds the maximum of %d", depth, g.Limits.MaxDepth))
+	}
+
+	if g.Limits.MaxComplexity > 0 && complexity > g.Limits.MaxComplexity {
+		return limitError(operation, "QUERY_TOO_COMPLEX", fmt.Sprintf("query complexity %d exceeds the maximum of %d", complexity, g.Limits.MaxComplexity))
+	}
+
+	return nil
+}
+
+func limitError(operation *ast.OperationDefinition, code, message string) *gqlerrors.FormattedError {
+	err := gqlerrors.FormatError(gqlerrors.NewLocatedError(message, []ast.Node{operation}))
+	err.Extensions = map[string]interface{}{"code": code}
+	return &err
+}
+
+func (a *costAnalysis) selectionSet(parent *graphql.Object, set *ast.SelectionSet, level int) (int, int) {
+	if set == nil || parent == nil {
+		return level - 1, 0
+	}
+
+	depth, complexity := level-1, 0
+	for _, selection := range set.Selections {
+		var d, c int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			d, c = a.field(parent, selection, level)
+		case *ast.InlineFragment:
+			d, c = a.selectionSet(a.conditionType(parent, selection.TypeCondition), selection.SelectionSet, level)
+		case *ast.FragmentSpread:
+			fragment, ok := a.fragments[selection.Name.Value]
+			if !ok {
+				continue
+			}
+			d, c = a.selectionSet(a.conditionType(parent, fragment.TypeCondition), fragment.SelectionSet, level)
+		}
+
+		if d > depth {
+			depth = d
+		}
+		complexity += c
+	}
+
+	return depth, complexity
+}
+
+func (a *costAnalysis) field(parent *graphql.Object, field *ast.Field, level int) (int, int) {
+	name := field.Name.Value
+	if strings.HasPrefix(name, "__") {
+		return level - 1, 0
+	}
+
+	definition, ok := parent.Fields()[name]
+	if !ok {
+		return level, 0
+	}
+
+	cost := 1
+	if c, ok := a.limits.FieldCosts[parent.Name()+"."+name]; ok {
+		cost = c
+	}
+
+	fieldType := definition.Type
+	if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+		fieldType = nonNull.OfType
+	}
+
+	multiplier := 1
+	if list, ok := fieldType.(*graphql.List); ok {
+		fieldType = list.OfType
+		if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+			fieldType = nonNull.OfType
+		}
+
+		multiplier = a.limits.DefaultListSize
+		if size, ok := a.limits.ListSizes[parent.Name()+"."+name]; ok {
+			multiplier = size
+		}
+	}
+
+	if size, ok := a.listSize(definition, field); ok {
+		multiplier = size
+	}
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	child, _ := fieldType.(*graphql.Object)
+	depth, complexity := a.selectionSet(child, field.SelectionSet, level+1)
+
+	return depth, cost + multiplier*complexity
+}
+
+func (a *costAnalysis) listSize(definition *graphql.FieldDefinition, field *ast.Field) (int, bool) {
+	for _, name := range listSizeArguments {
+		for _, argument := range field.Arguments {
+			if argument.Name.Value != name {
+				continue
+			}
+			if size, ok := a.intValue(argument.Value); ok {
+				return size, true
+			}
+		}
+
+		for _, argument := range definition.Args {
+			if argument.Name() == name {
+				if size, ok := argument.DefaultValue.(int); ok {
+					return size, true
+				}
+			}
+		}
+	}
+
+	// connections without first or last return a page of the default size
+	for _, argument := range definition.Args {
+		if argument.Name() == "first" || argument.Name() == "last" {
+			return defaultPageSize, true
+		}
+	}
+
+	return 0, false
+}
+
+func (a *costAnalysis) intValue(value ast.Value) (int, bool) {
+	switch value := value.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(value.Value)
+		return n, err == nil
+	case *ast.Variable:
+		switch v := a.variables[value.Name.Value].(type) {
+		case int:
+			return v, true
+		case float64:
+			return int(v), true
+		}
+	}
+
+	return 0, false
+}
+
+func (a *costAnalysis) conditionType(parent *graphql.Object, condition *ast.Named) *graphql.Object {
+	if condition == nil {
+		return parent
+	}
+
+	if object, ok := a.schema.Type(condition.Name.Value).(*graphql.Object); ok {
+		return object
+	}
+
+	return parent
+}
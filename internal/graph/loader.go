@@ -0,0 +1,208 @@
+﻿package graph
+
+import (
+	"context"
+	"movie-library/internal/models"
+	"sync"
+)
+
+type loadersKey struct{}
+
+// loader collects keys requested while a single GraphQL request is resolving and fetches
+// them with one batched call the first time any of the returned thunks is evaluated.
+type loader struct {
+	mu      sync.Mutex
+	fetch   func(keys []int) (map[int]interface{}, error)
+	pending []int
+	results map[int]interface{}
+	errs    map[int]error
+}
+
+func newLoader(fetch func(keys []int) (map[int]interface{}, error)) *loader {
+	return &loader{
+		fetch:   fetch,
+		results: make(map[int]interface{}),
+		errs:    make(map[int]error),
+	}
+}
+
+func (l *loader) load(key int) func() (interface{}, error) {
+	l.mu.Lock()
+	_, loaded := l.results[key]
+	_, failed := l.errs[key]
+	if !loaded && !failed {
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.pending) > 0 {
+			keys := unique(l.pending)
+			l.pending = nil
+
+			results, err := l.fetch(keys)
+			for _, k := range keys {
+				if err != nil {
+					l.errs[k] = err
+					continue
+				}
+				l.results[k] = results[k]
+			}
+		}
+
+		return l.results[key], l.errs[key]
+	}
+}
+
+type loaders struct {
+	g           *Graph
+	movies      *loader
+	movieGenres *loader
+	movieTags   *loader
+	// movieCollections, movieRelations and collectionMovies batch the collection fields.
+	movieCollections *loader
+	movieRelations   *loader
+	collectionMovies *loader
+	mu               sync.Mutex
+	genreMovies      map[[2]int]*loader
+	genres           []*models.Genre
+	genresErr        error
+	genresOnce       sync.Once
+}
+
+func (g *Graph) newLoaders() *loaders {
+	return &loaders{
+		g: g,
+		movies: newLoader(func(ids []int) (map[int]interface{}, error) {
+			movies, err := g.DB.MoviesByIDs(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			results := make(map[int]interface{}, len(movies))
+			for _, movie := range movies {
+				results[movie.ID] = movie
+			}
+			return results, nil
+		}),
+		movieGenres: newLoader(func(ids []int) (map[int]interface{}, error) {
+			genres, err := g.DB.GenresForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			results := make(map[int]interface{}, len(ids))
+			for _, id := range ids {
+				results[id] = genres[id]
+			}
+			return results, nil
+		}),
+		movieTags: newLoader(func(ids []int) (map[int]interface{}, error) {
+			tags, err := g.DB.TagsForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			results := make(map[int]interface{}, len(ids))
+			for _, id := range ids {
+				results[id] = tags[id]
+			}
+			return results, nil
+		}),
+		movieCollections: newLoader(func(ids []int) (map[int]interface{}, error) {
+			entries, err := g.DB.CollectionEntriesForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			results := make(map[int]interface{}, len(ids))
+			for _, id := range ids {
+				results[id] = entries[id]
+			}
+			return results, nil
+		}),
+		movieRelations: newLoader(func(ids []int) (map[int]interface{}, error) {
+			relations, err := g.DB.RelationsForMovies(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			results := make(map[int]interface{}, len(ids))
+			for _, id := range ids {
+				results[id] = relations[id]
+			}
+			return results, nil
+		}),
+		collectionMovies: newLoader(func(ids []int) (map[int]interface{}, error) {
+			movies, err := g.DB.MoviesForCollections(ids)
+			if err != nil {
+				return nil, err
+			}
+
+			results := make(map[int]interface{}, len(ids))
+			for _, id := range ids {
+				results[id] = movies[id]
+			}
+			return results, nil
+		}),
+		genreMovies: make(map[[2]int]*loader),
+	}
+}
+
+// allGenres returns every genre, fetched at most once per request, for resolving the genre
+// hierarchy.
+func (l *loaders) allGenres() ([]*models.Genre, error) {
+	l.genresOnce.Do(func() {
+		l.genres, l.genresErr = l.g.DB.Genres()
+	})
+	return l.genres, l.genresErr
+}
+
+// moviesInGenre returns the loader for one page of movies per genre; genres requested with
+// the same paging arguments share a batch.
+func (l *loaders) moviesInGenre(limit, offset int) *loader {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := [2]int{limit, offset}
+	if _, ok := l.genreMovies[key]; !ok {
+		l.genreMovies[key] = newLoader(func(ids []int) (map[int]interface{}, error) {
+			movies, err := l.g.DB.MoviesForGenres(ids, limit, offset)
+			if err != nil {
+				return nil, err
+			}
+
+			results := make(map[int]interface{}, len(ids))
+			for _, id := range ids {
+				results[id] = movies[id]
+			}
+			return results, nil
+		})
+	}
+
+	return l.genreMovies[key]
+}
+
+// loaders returns the request's loaders. Subscription payloads are resolved without
+// request-scoped loaders, so they get fresh ones to avoid serving stale results.
+func (g *Graph) loaders(ctx context.Context) *loaders {
+	if l, ok := ctx.Value(loadersKey{}).(*loaders); ok {
+		return l
+	}
+	return g.newLoaders()
+}
+
+func unique(keys []int) []int {
+	seen := make(map[int]bool, len(keys))
+	var result []int
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			result = append(result, k)
+		}
+	}
+	return result
+}
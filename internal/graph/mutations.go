@@ -0,0 +1,444 @@
+﻿package graph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"log"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"strings"
+	"time"
+)
+
+// MutationHook is called after every successful mutation so callers can publish its events.
+type MutationHook func()
+
+func (g *Graph) mutationFields() graphql.Fields {
+	movieInputType := graphql.NewInputObject(
+		graphql.InputObjectConfig{
+			Name: "MovieInput",
+			Fields: graphql.InputObjectConfigFieldMap{
+				"title": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"description": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"release_date": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewNonNull(graphql.DateTime),
+				},
+				"run_time": &graphql.InputObjectFieldConfig{
+					Type: graphql.Int,
+				},
+				"mpaa_rating": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"image": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"imdb_id": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"tmdb_id": &graphql.InputObjectFieldConfig{
+					Type: graphql.Int,
+				},
+				"wikidata_id": &graphql.InputObjectFieldConfig{
+					Type: graphql.String,
+				},
+				"genres_array": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewList(graphql.NewNonNull(graphql.Int)),
+				},
+			},
+		},
+	)
+
+	fields := graphql.Fields{
+		"createMovie": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Create a movie; force creates it even if another movie has the same title and release year",
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(movieInputType),
+				},
+				"force": &graphql.ArgumentConfig{
+					Type:         graphql.Boolean,
+					DefaultValue: false,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				movie, err := movieFromInput(params.Args["input"])
+				if err != nil {
+					return nil, err
+				}
+
+				duplicate, err := g.DB.FindDuplicateMovie(movie)
+				if err != nil {
+					return nil, err
+				}
+
+				force, _ := params.Args["force"].(bool)
+				if duplicate != nil && (duplicate.Reason != models.DuplicateTitle || !force) {
+					return nil, fmt.Errorf("movie %d has the same %s", duplicate.Movie.ID, duplicate.Reason)
+				}
+
+				if g.Poster != nil {
+					movie = g.Poster(movie)
+				}
+
+				newID, err := g.DB.CreateMovie(params.Context, movie)
+				if err != nil {
+					return nil, err
+				}
+
+				g.mutated()
+
+				return committed(func() (interface{}, error) {
+					return g.DB.GetMovieByID(newID)
+				})
+			},
+		},
+		"updateMovie": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Replace a movie's details if its version is still the one given",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"input": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(movieInputType),
+				},
+				"version": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				movie, err := movieFromInput(params.Args["input"])
+				if err != nil {
+					return nil, err
+				}
+
+				before, err := g.DB.GetMovieByID(id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("movie not found")
+				}
+				if err != nil {
+					return nil, err
+				}
+
+				if version, _ := params.Args["version"].(int); version != before.Version {
+					return nil, repository.ErrVersionMismatch
+				}
+
+				movie.ID = id
+				movie.Version = before.Version
+				if movie.Image == "" {
+					movie.Image = before.Image
+				}
+
+				// external IDs left out of the input are kept
+				input, _ := params.Args["input"].(map[string]interface{})
+				if _, ok := input["imdb_id"]; !ok {
+					movie.IMDbID = before.IMDbID
+				}
+				if _, ok := input["tmdb_id"]; !ok {
+					movie.TMDBID = before.TMDBID
+				}
+				if _, ok := input["wikidata_id"]; !ok {
+					movie.WikidataID = before.WikidataID
+				}
+
+				// genres are only replaced when the input lists them
+				if _, ok := input["genres_array"]; !ok {
+					movie.GenresArray = nil
+				}
+
+				err = g.DB.UpdateMovie(params.Context, movie)
+				if err != nil {
+					return nil, err
+				}
+				g.mutated()
+
+				return committed(func() (interface{}, error) {
+					return g.DB.GetMovieByID(id)
+				})
+			},
+		},
+		"deleteMovie": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Move a movie to the trash",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				err := g.DB.DeleteMovie(params.Context, id)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("movie not found")
+				}
+				if err != nil {
+					return nil, err
+				}
+				g.mutated()
+
+				return true, nil
+			},
+		},
+		"setMovieGenres": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Replace the genres of a movie",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"genreIds": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int))),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				genreIDs := intList(params.Args["genreIds"])
+				err := g.DB.CreateMovieGenre(params.Context, id, genreIDs)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("movie not found")
+				}
+				if err != nil {
+					return nil, err
+				}
+				g.mutated()
+
+				return committed(func() (interface{}, error) {
+					return g.DB.GetMovieByID(id)
+				})
+			},
+		},
+		"createGenre": &graphql.Field{
+			Type:        g.genreType,
+			Description: "Create a genre",
+			Args: graphql.FieldConfigArgument{
+				"genre": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"parentId": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				name, _ := params.Args["genre"].(string)
+				name = strings.TrimSpace(name)
+				if name == "" {
+					return nil, errors.New("genre is required")
+				}
+
+				parentID := optionalInt(params.Args["parentId"])
+				newID, err := g.DB.CreateGenre(params.Context, name, parentID)
+				if err != nil {
+					return nil, err
+				}
+
+				g.mutated()
+
+				return &models.Genre{ID: newID, Genre: name, ParentID: parentID}, nil
+			},
+		},
+		"updateGenre": &graphql.Field{
+			Type:        g.genreType,
+			Description: "Rename a genre and set its parent; omitting parentId makes it a top-level genre",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"genre": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.String),
+				},
+				"parentId": &graphql.ArgumentConfig{
+					Type: graphql.Int,
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				name, _ := params.Args["genre"].(string)
+				name = strings.TrimSpace(name)
+				if name == "" {
+					return nil, errors.New("genre is required")
+				}
+
+				err := g.DB.UpdateGenre(params.Context, id, name, optionalInt(params.Args["parentId"]))
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("genre not found")
+				}
+				if err != nil {
+					return nil, err
+				}
+				g.mutated()
+
+				return committed(func() (interface{}, error) {
+					return g.DB.GetGenre(id)
+				})
+			},
+		},
+		"setMovieTags": &graphql.Field{
+			Type:        g.movieType,
+			Description: "Replace the tags of a movie, creating new tags as needed",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.Int),
+				},
+				"tags": &graphql.ArgumentConfig{
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				},
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				if err := g.authorize(params.Context); err != nil {
+					return nil, err
+				}
+
+				id, _ := params.Args["id"].(int)
+				tags, err := models.NormalizeTags(stringList(params.Args["tags"]))
+				if err != nil {
+					return nil, err
+				}
+
+				err = g.DB.SetMovieTags(params.Context, id, tags)
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil, errors.New("movie not found")
+				}
+				if err != nil {
+					return nil, err
+				}
+				g.mutated()
+
+				return committed(func() (interface{}, error) {
+					return g.DB.GetMovieByID(id)
+				})
+			},
+		},
+	}
+
+	for name, field := range g.collectionMutationFields() {
+		fields[name] = field
+	}
+
+	return fields
+}
+
+func (g *Graph) authorize(ctx context.Context) error {
+	if g.Authorize == nil {
+		return errors.New("not authorized")
+	}
+	return g.Authorize(ctx)
+}
+
+func (g *Graph) mutated() {
+	if g.OnMutation != nil {
+		g.OnMutation()
+	}
+}
+
+// committed resolves a mutation whose write has already been committed to what load reads
+// back. A failed read cannot undo the write, so it is logged and the field resolves to null.
+func committed(load func() (interface{}, error)) (interface{}, error) {
+	result, err := load()
+	if err != nil {
+		log.Println("graphql: read after write:", err)
+		return nil, nil
+	}
+	return result, nil
+}
+
+func movieFromInput(value interface{}) (models.Movie, error) {
+	var movie models.Movie
+	input, ok := value.(map[string]interface{})
+	if !ok {
+		return movie, errors.New("input is required")
+	}
+
+	movie.Title, _ = input["title"].(string)
+	movie.Title = strings.TrimSpace(movie.Title)
+	if movie.Title == "" {
+		return movie, errors.New("title is required")
+	}
+
+	movie.ReleaseDate, ok = input["release_date"].(time.Time)
+	if !ok {
+		return movie, errors.New("release_date must be an RFC 3339 date time")
+	}
+
+	movie.RunTime, _ = input["run_time"].(int)
+	if movie.RunTime < 0 {
+		return movie, errors.New("run_time cannot be negative")
+	}
+
+	movie.Description, _ = input["description"].(string)
+	movie.MPAARating, _ = input["mpaa_rating"].(string)
+	movie.Image, _ = input["image"].(string)
+	movie.GenresArray = intList(input["genres_array"])
+
+	movie.IMDbID, _ = input["imdb_id"].(string)
+	movie.TMDBID, _ = input["tmdb_id"].(int)
+	movie.WikidataID, _ = input["wikidata_id"].(string)
+	if err := models.NormalizeExternalIDs(&movie); err != nil {
+		return movie, err
+	}
+
+	return movie, nil
+}
+
+func optionalInt(value interface{}) *int {
+	i, ok := value.(int)
+	if !ok {
+		return nil
+	}
+	return &i
+}
+
+func stringList(value interface{}) []string {
+	values, _ := value.([]interface{})
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+func intList(value interface{}) []int {
+	values, _ := value.([]interface{})
+	ints := make([]int, 0, len(values))
+	for _, v := range values {
+		if i, ok := v.(int); ok {
+			ints = append(ints, i)
+		}
+	}
+	return ints
+}
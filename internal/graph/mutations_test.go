@@ -0,0 +1,93 @@
+﻿package graph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"strings"
+	"testing"
+)
+
+type movieRepo struct {
+	repository.DatabaseRepo
+	movie   *models.Movie
+	err     error
+	updated []models.Movie
+}
+
+func (r *movieRepo) GetMovieByID(id int) (*models.Movie, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.movie == nil || r.movie.ID != id {
+		return nil, sql.ErrNoRows
+	}
+	movie := *r.movie
+	return &movie, nil
+}
+
+func (r *movieRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	r.updated = append(r.updated, movie)
+	return nil
+}
+
+func TestUpdateMovieChecksVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		err     error
+		want    string
+		updated bool
+	}{
+		{
+			name:  "version omitted",
+			query: `mutation { updateMovie(id: 1, input: {title: "Alien", release_date: "1979-05-25T00:00:00Z"}) { id } }`,
+			want:  `argument "version" of type "Int!" is required`,
+		},
+		{
+			name:  "stale version",
+			query: `mutation { updateMovie(id: 1, version: 2, input: {title: "Alien", release_date: "1979-05-25T00:00:00Z"}) { id } }`,
+			want:  repository.ErrVersionMismatch.Error(),
+		},
+		{
+			name:  "unknown movie",
+			query: `mutation { updateMovie(id: 2, version: 3, input: {title: "Alien", release_date: "1979-05-25T00:00:00Z"}) { id } }`,
+			want:  "movie not found",
+		},
+		{
+			name:  "database failure",
+			query: `mutation { updateMovie(id: 1, version: 3, input: {title: "Alien", release_date: "1979-05-25T00:00:00Z"}) { id } }`,
+			err:   errors.New("connection refused"),
+			want:  "connection refused",
+		},
+		{
+			name:    "current version",
+			query:   `mutation { updateMovie(id: 1, version: 3, input: {title: "Alien", release_date: "1979-05-25T00:00:00Z"}) { id } }`,
+			updated: true,
+		},
+	}
+
+	for _, test := range tests {
+		db := &movieRepo{movie: &models.Movie{ID: 1, Title: "Alien", Version: 3}, err: test.err}
+		g, err := New(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.Authorize = func(ctx context.Context) error { return nil }
+
+		result := g.Query(context.Background(), Request{Query: test.query})
+		var messages []string
+		for _, err := range result.Errors {
+			messages = append(messages, err.Message)
+		}
+
+		if got := strings.Join(messages, "; "); test.want == "" && got != "" || !strings.Contains(got, test.want) {
+			t.Errorf("%s: got errors %q, want %q", test.name, got, test.want)
+		}
+		if (len(db.updated) > 0) != test.updated {
+			t.Errorf("%s: updated = %v, want %v", test.name, len(db.updated) > 0, test.updated)
+		}
+	}
+}
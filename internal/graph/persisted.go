@@ -0,0 +1,284 @@
+﻿package graph
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"log"
+	"movie-library/internal/invalidation"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PersistedQueries stores query documents by the hex SHA-256 hash of their text. It backs
+// automatic persisted queries and, when AllowListOnly is set, restricts the server to
+// operations that were registered ahead of time. Registered queries are kept for good; queries
+// added by clients are kept in an LRU of at most MaxEntries documents.
+type PersistedQueries struct {
+	AllowListOnly bool
+	MaxEntries    int
+	// Source loads the queries registered at runtime, which are shared by every replica.
+	Source func() (map[string]string, error)
+
+	mu         sync.Mutex
+	registered map[string]string
+	automatic  map[string]*list.Element
+	order      *list.List
+}
+
+type persistedEntry struct {
+	hash  string
+	query string
+}
+
+func NewPersistedQueries(allowListOnly bool, maxEntries int) *PersistedQueries {
+	return &PersistedQueries{
+		AllowListOnly: allowListOnly,
+		MaxEntries:    maxEntries,
+		registered:    make(map[string]string),
+		automatic:     make(map[string]*list.Element),
+		order:         list.New(),
+	}
+}
+
+func QueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// Register adds a query that is never evicted, such as one on the allow list.
+func (p *PersistedQueries) Register(query string) string {
+	hash := QueryHash(query)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.registered[hash] = query
+	if element, ok := p.automatic[hash]; ok {
+		p.remove(element)
+	}
+
+	return hash
+}
+
+// Add adds a query sent by a client, evicting the least recently used client queries once
+// there are more than MaxEntries of them.
+func (p *PersistedQueries) Add(query string) string {
+	hash := QueryHash(query)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.registered[hash]; ok {
+		return hash
+	}
+
+	if element, ok := p.automatic[hash]; ok {
+		p.order.MoveToFront(element)
+		return hash
+	}
+
+	p.automatic[hash] = p.order.PushFront(&persistedEntry{hash: hash, query: query})
+
+	for p.MaxEntries > 0 && p.order.Len() > p.MaxEntries {
+		p.remove(p.order.Back())
+	}
+
+	return hash
+}
+
+func (p *PersistedQueries) Get(hash string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if query, ok := p.registered[hash]; ok {
+		return query, true
+	}
+
+	element, ok := p.automatic[hash]
+	if !ok {
+		return "", false
+	}
+
+	p.order.MoveToFront(element)
+	return element.Value.(*persistedEntry).query, true
+}
+
+func (p *PersistedQueries) Hashes() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hashes := make([]string, 0, len(p.registered)+len(p.automatic))
+	for hash := range p.registered {
+		hashes = append(hashes, hash)
+	}
+	for hash := range p.automatic {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	return hashes
+}
+
+// Reload registers every query from Source.
+func (p *PersistedQueries) Reload() error {
+	if p.Source == nil {
+		return nil
+	}
+
+	queries, err := p.Source()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range queries {
+		p.Register(query)
+	}
+
+	return nil
+}
+
+// Invalidate reloads the registered queries when another replica registers one, and ignores
+// catalog changes, which do not affect query documents.
+func (p *PersistedQueries) Invalidate(msg invalidation.Message) {
+	if msg.Entity != invalidation.PersistedQuery {
+		return
+	}
+
+	if err := p.Reload(); err != nil {
+		log.Println("persisted queries: reload:", err)
+	}
+}
+
+// InvalidateAll drops the queries added by clients, which resend any query the server no
+// longer knows, and reloads the registered ones in case an announcement was missed.
+func (p *PersistedQueries) InvalidateAll() {
+	p.mu.Lock()
+	p.automatic = make(map[string]*list.Element)
+	p.order.Init()
+	p.mu.Unlock()
+
+	if err := p.Reload(); err != nil {
+		log.Println("persisted queries: reload:", err)
+	}
+}
+
+func (p *PersistedQueries) remove(element *list.Element) {
+	p.order.Remove(element)
+	delete(p.automatic, element.Value.(*persistedEntry).hash)
+}
+
+// RegisterQuery validates query against the schema and stores it so that every replica adds
+// it to its persisted queries.
+func (g *Graph) RegisterQuery(ctx context.Context, query string) (string, error) {
+	if g.Persisted == nil {
+		return "", fmt.Errorf("persisted queries are not enabled")
+	}
+
+	_, errs := g.parse(Request{Query: query})
+	if len(errs) > 0 {
+		return "", errs[0]
+	}
+
+	hash := QueryHash(query)
+	if err := g.DB.InsertPersistedQuery(ctx, hash, query); err != nil {
+		return "", err
+	}
+
+	// the notification reaches this replica too, but the caller may use the hash straight away
+	return g.Persisted.Register(query), nil
+}
+
+// LoadPersistedQueries registers every *.graphql file in dir under the hash of its exact
+// contents, so clients can send the hash of the file as it is stored.
+func (g *Graph) LoadPersistedQueries(dir string) (int, error) {
+	if g.Persisted == nil {
+		return 0, fmt.Errorf("persisted queries are not enabled")
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.graphql"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			return 0, err
+		}
+
+		_, errs := g.parse(Request{Query: string(contents)})
+		if len(errs) > 0 {
+			return 0, fmt.Errorf("%s: %w", file, errs[0])
+		}
+		g.Persisted.Register(string(contents))
+	}
+
+	return len(files), nil
+}
+
+// persistedQuery resolves the query text of a request that uses the persistedQuery
+// extension. It reports whether the query is new and should be registered once it validates.
+func (g *Graph) persistedQuery(request Request) (Request, bool, *gqlerrors.FormattedError) {
+	if g.Persisted == nil {
+		return request, false, nil
+	}
+
+	hash := persistedQueryHash(request)
+
+	if hash == "" {
+		if g.Persisted.AllowListOnly {
+			if _, ok := g.Persisted.Get(QueryHash(request.Query)); !ok {
+				return request, false, persistedError("PersistedQueryNotAllowed", "PERSISTED_QUERY_NOT_ALLOWED")
+			}
+		}
+		return request, false, nil
+	}
+
+	if request.Query == "" {
+		query, ok := g.Persisted.Get(hash)
+		if !ok {
+			if g.Persisted.AllowListOnly {
+				return request, false, persistedError("PersistedQueryNotAllowed", "PERSISTED_QUERY_NOT_ALLOWED")
+			}
+			return request, false, persistedError("PersistedQueryNotFound", "PERSISTED_QUERY_NOT_FOUND")
+		}
+		request.Query = query
+		return request, false, nil
+	}
+
+	if QueryHash(request.Query) != hash {
+		return request, false, persistedError("provided sha does not match query", "PERSISTED_QUERY_HASH_MISMATCH")
+	}
+
+	if _, ok := g.Persisted.Get(hash); !ok {
+		if g.Persisted.AllowListOnly {
+			return request, false, persistedError("PersistedQueryNotAllowed", "PERSISTED_QUERY_NOT_ALLOWED")
+		}
+		return request, true, nil
+	}
+
+	return request, false, nil
+}
+
+func persistedQueryHash(request Request) string {
+	extension, ok := request.Extensions["persistedQuery"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	hash, _ := extension["sha256Hash"].(string)
+	return strings.ToLower(hash)
+}
+
+func persistedError(message, code string) *gqlerrors.FormattedError {
+	err := gqlerrors.NewFormattedError(message)
+	err.Extensions = map[string]interface{}{"code": code}
+	return &err
+}
@@ -0,0 +1,66 @@
+﻿package graph
+
+import (
+	"movie-library/internal/invalidation"
+	"testing"
+)
+
+func TestPersistedQueriesEvictLeastRecentlyUsed(t *testing.T) {
+	p := NewPersistedQueries(false, 2)
+	registered := p.Register("{ genres { id } }")
+	first := p.Add("{ list { id } }")
+	second := p.Add("{ list { title } }")
+
+	if _, ok := p.Get(first); !ok {
+		t.Fatal("expected first query to be stored")
+	}
+
+	third := p.Add("{ list { runtime } }")
+
+	if _, ok := p.Get(second); ok {
+		t.Fatal("expected least recently used query to be evicted")
+	}
+	for _, hash := range []string{registered, first, third} {
+		if _, ok := p.Get(hash); !ok {
+			t.Fatalf("expected %s to be kept", hash)
+		}
+	}
+}
+
+func TestPersistedQueriesReloadSharedRegistrations(t *testing.T) {
+	shared := map[string]string{}
+	p := NewPersistedQueries(true, 10)
+	p.Source = func() (map[string]string, error) {
+		return shared, nil
+	}
+
+	query := "{ genres { genre } }"
+	hash := QueryHash(query)
+	shared[hash] = query
+
+	p.Invalidate(invalidation.Message{Entity: "movie", ID: 1})
+	if _, ok := p.Get(hash); ok {
+		t.Fatal("expected catalog changes not to reload the registered queries")
+	}
+
+	// another replica registered the query and announced it
+	p.Invalidate(invalidation.Message{Entity: invalidation.PersistedQuery})
+	if _, ok := p.Get(hash); !ok {
+		t.Fatal("expected the announced query to be registered")
+	}
+
+	added := p.Add("{ list { id } }")
+	other := "{ genres { id } }"
+	shared[QueryHash(other)] = other
+
+	// after a reconnect announcements may have been missed
+	p.InvalidateAll()
+	if _, ok := p.Get(added); ok {
+		t.Fatal("expected client queries to be dropped")
+	}
+	for _, hash := range []string{hash, QueryHash(other)} {
+		if _, ok := p.Get(hash); !ok {
+			t.Fatalf("expected registered query %s to be kept", hash)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+﻿package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"log"
+	"strings"
+)
+
+// Request is a GraphQL-over-HTTP request.
+type Request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Query parses, validates and executes a request. Syntax and validation failures are
+// reported in the result's errors with their locations, the same way execution errors are.
+func (g *Graph) Query(ctx context.Context, request Request) *graphql.Result {
+	request, register, persistedErr := g.persistedQuery(request)
+	if persistedErr != nil {
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{*persistedErr}}
+	}
+
+	document, errs := g.parse(request)
+	if len(errs) > 0 {
+		return &graphql.Result{Errors: errs}
+	}
+
+	operation := findOperation(document, request.OperationName)
+	if operation != nil && operation.Operation == ast.OperationTypeSubscription {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(errors.New("subscriptions are only available over WebSocket"))}
+	}
+
+	if err := g.checkLimits(document, request); err != nil {
+		log.Printf("graphql: rejected query: %s: %s", err.Message, strings.Join(strings.Fields(request.Query), " "))
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{*err}}
+	}
+
+	if register {
+		g.Persisted.Add(request.Query)
+	}
+
+	ctx = context.WithValue(ctx, loadersKey{}, g.newLoaders())
+	// Execute returns as soon as its context is done but leaves resolvers running, so a mutation
+	// cut off by the timeout could still commit after being reported as timed out. Mutations run
+	// to completion instead, bounded by the repository's own timeouts.
+	if g.Limits.Timeout > 0 && (operation == nil || operation.Operation != ast.OperationTypeMutation) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.Limits.Timeout)
+		defer cancel()
+	}
+
+	resp := graphql.Execute(graphql.ExecuteParams{
+		Schema:        g.schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       ctx,
+	})
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Printf("graphql: query exceeded timeout of %s: %s", g.Limits.Timeout, strings.Join(strings.Fields(request.Query), " "))
+		err := gqlerrors.NewFormattedError(fmt.Sprintf("query exceeded the execution timeout of %s", g.Limits.Timeout))
+		err.Extensions = map[string]interface{}{"code": "QUERY_TIMEOUT"}
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{err}}
+	}
+
+	return resp
+}
+
+// OperationType returns the type of the operation the request selects: "query", "mutation"
+// or "subscription". It returns an empty string when the document cannot be parsed or the
+// operation cannot be found.
+func (g *Graph) OperationType(request Request) string {
+	if request.Query == "" && g.Persisted != nil {
+		request.Query, _ = g.Persisted.Get(persistedQueryHash(request))
+	}
+
+	document, err := parser.Parse(parser.ParseParams{Source: request.Query})
+	if err != nil {
+		return ""
+	}
+
+	operation := findOperation(document, request.OperationName)
+	if operation == nil {
+		return ""
+	}
+
+	return operation.Operation
+}
+
+func (g *Graph) parse(request Request) (*ast.Document, []gqlerrors.FormattedError) {
+	if request.Query == "" {
+		return nil, []gqlerrors.FormattedError{gqlerrors.NewFormattedError("query is required")}
+	}
+
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{
+			Body: []byte(request.Query),
+			Name: "GraphQL request",
+		}),
+	})
+	if err != nil {
+		return nil, gqlerrors.FormatErrors(err)
+	}
+
+	validation := graphql.ValidateDocument(&g.schema, document, nil)
+	if !validation.IsValid {
+		return nil, validation.Errors
+	}
+
+	return document, nil
+}
+
+func findOperation(document *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = operation
+			continue
+		}
+
+		if operation.Name != nil && operation.Name.Value == name {
+			return operation
+		}
+	}
+
+	return found
+}
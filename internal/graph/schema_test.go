@@ -0,0 +1,22 @@
+﻿package graph
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSchemaMatchesCommittedSDL(t *testing.T) {
+	g, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	committed, err := os.ReadFile("schema.graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.SDL() != string(committed) {
+		t.Fatal("the GraphQL schema has changed; review the change and run go run ./cmd/schema > internal/graph/schema.graphql")
+	}
+}
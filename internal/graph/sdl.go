@@ -0,0 +1,146 @@
+﻿package graph
+
+import (
+	"fmt"
+	"github.com/graphql-go/graphql"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var builtInScalars = map[string]bool{
+	"String":  true,
+	"Int":     true,
+	"Float":   true,
+	"Boolean": true,
+	"ID":      true,
+}
+
+// SDL prints the schema in GraphQL schema definition language. Types and fields are sorted
+// by name so the output only changes when the schema does.
+func (g *Graph) SDL() string {
+	var b strings.Builder
+
+	b.WriteString("schema {\n")
+	fmt.Fprintf(&b, "  query: %s\n", g.schema.QueryType().Name())
+	if mutation := g.schema.MutationType(); mutation != nil {
+		fmt.Fprintf(&b, "  mutation: %s\n", mutation.Name())
+	}
+	if subscription := g.schema.SubscriptionType(); subscription != nil {
+		fmt.Fprintf(&b, "  subscription: %s\n", subscription.Name())
+	}
+	b.WriteString("}\n")
+
+	typeMap := g.schema.TypeMap()
+	names := make([]string, 0, len(typeMap))
+	for name := range typeMap {
+		if strings.HasPrefix(name, "__") || builtInScalars[name] {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b.WriteString("\n")
+		switch t := typeMap[name].(type) {
+		case *graphql.Scalar:
+			writeDescription(&b, t.Description(), "")
+			fmt.Fprintf(&b, "scalar %s\n", t.Name())
+		case *graphql.Enum:
+			writeDescription(&b, t.Description(), "")
+			fmt.Fprintf(&b, "enum %s {\n", t.Name())
+			// Values returns the enum's own slice, so sort a copy
+			values := append([]*graphql.EnumValueDefinition(nil), t.Values()...)
+			sort.Slice(values, func(i, j int) bool { return values[i].Name < values[j].Name })
+			for _, value := range values {
+				writeDescription(&b, value.Description, "  ")
+				fmt.Fprintf(&b, "  %s%s\n", value.Name, deprecated(value.DeprecationReason))
+			}
+			b.WriteString("}\n")
+		case *graphql.InputObject:
+			writeDescription(&b, t.Description(), "")
+			fmt.Fprintf(&b, "input %s {\n", t.Name())
+			fields := t.Fields()
+			for _, fieldName := range sortedKeys(fields) {
+				field := fields[fieldName]
+				writeDescription(&b, field.Description(), "  ")
+				fmt.Fprintf(&b, "  %s: %s%s\n", field.Name(), field.Type, defaultValue(field.Type, field.DefaultValue))
+			}
+			b.WriteString("}\n")
+		case *graphql.Object:
+			writeDescription(&b, t.Description(), "")
+			fmt.Fprintf(&b, "type %s {\n", t.Name())
+			fields := t.Fields()
+			for _, fieldName := range sortedKeys(fields) {
+				field := fields[fieldName]
+				writeDescription(&b, field.Description, "  ")
+				fmt.Fprintf(&b, "  %s%s: %s%s\n", field.Name, arguments(field.Args), field.Type, deprecated(field.DeprecationReason))
+			}
+			b.WriteString("}\n")
+		}
+	}
+
+	return b.String()
+}
+
+func arguments(args []*graphql.Argument) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	sorted := make([]*graphql.Argument, len(args))
+	copy(sorted, args)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name() < sorted[j].Name() })
+
+	parts := make([]string, 0, len(sorted))
+	for _, arg := range sorted {
+		parts = append(parts, fmt.Sprintf("%s: %s%s", arg.Name(), arg.Type, defaultValue(arg.Type, arg.DefaultValue)))
+	}
+
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+func defaultValue(t graphql.Input, value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	if enum, ok := t.(*graphql.Enum); ok {
+		for _, v := range enum.Values() {
+			if v.Value == value {
+				return " = " + v.Name
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return " = " + strconv.Quote(v)
+	default:
+		return fmt.Sprintf(" = %v", v)
+	}
+}
+
+func deprecated(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return fmt.Sprintf(" @deprecated(reason: %s)", strconv.Quote(reason))
+}
+
+func writeDescription(b *strings.Builder, description, indent string) {
+	if description == "" {
+		return
+	}
+	fmt.Fprintf(b, "%s%s\n", indent, strconv.Quote(description))
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
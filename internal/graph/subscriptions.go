@@ -0,0 +1,127 @@
+﻿package graph
+
+import (
+	"context"
+	"errors"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"log"
+	"movie-library/internal/events"
+	"strings"
+)
+
+const subscriptionBuffer = 16
+
+func (g *Graph) subscriptionFields() graphql.Fields {
+	movieEvent := func(description string, types ...string) *graphql.Field {
+		return &graphql.Field{
+			Type:        g.movieType,
+			Description: description,
+			Subscribe: func(params graphql.ResolveParams) (interface{}, error) {
+				return g.subscribe(params.Context, types...)
+			},
+			Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+				event, ok := params.Source.(events.Event)
+				if !ok {
+					return nil, nil
+				}
+				return event.Movie, nil
+			},
+		}
+	}
+
+	return graphql.Fields{
+		"movieAdded":   movieEvent("A movie was created or restored from the trash", events.MovieCreated, events.MovieRestored),
+		"movieUpdated": movieEvent("A movie's details or genres changed", events.MovieUpdated),
+		"movieDeleted": movieEvent("A movie was moved to the trash", events.MovieDeleted),
+	}
+}
+
+// subscribe forwards bus events of the given types until ctx is cancelled.
+func (g *Graph) subscribe(ctx context.Context, types ...string) (interface{}, error) {
+	if g.Events == nil {
+		return nil, errors.New("subscriptions are not enabled")
+	}
+
+	if err := g.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	source, unsubscribe := g.Events.Subscribe(subscriptionBuffer)
+	out := make(chan interface{})
+
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-source:
+				if !ok {
+					return
+				}
+
+				if !matches(event.Type, types) {
+					continue
+				}
+
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Subscribe starts a subscription operation. The returned channel yields one result per
+// event and is closed when ctx is cancelled or the request cannot be executed.
+func (g *Graph) Subscribe(ctx context.Context, request Request) <-chan *graphql.Result {
+	single := func(resp *graphql.Result) <-chan *graphql.Result {
+		ch := make(chan *graphql.Result, 1)
+		ch <- resp
+		close(ch)
+		return ch
+	}
+
+	request, register, persistedErr := g.persistedQuery(request)
+	if persistedErr != nil {
+		return single(&graphql.Result{Errors: []gqlerrors.FormattedError{*persistedErr}})
+	}
+
+	document, errs := g.parse(request)
+	if len(errs) > 0 {
+		return single(&graphql.Result{Errors: errs})
+	}
+
+	if err := g.checkLimits(document, request); err != nil {
+		log.Printf("graphql: rejected subscription: %s: %s", err.Message, strings.Join(strings.Fields(request.Query), " "))
+		return single(&graphql.Result{Errors: []gqlerrors.FormattedError{*err}})
+	}
+
+	if register {
+		g.Persisted.Add(request.Query)
+	}
+
+	return graphql.ExecuteSubscription(graphql.ExecuteParams{
+		Schema:        g.schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       ctx,
+	})
+}
+
+func matches(eventType string, types []string) bool {
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+﻿package invalidation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"log"
+	"sync"
+	"time"
+)
+
+// Channel is the Postgres notification channel catalog changes are announced on.
+const Channel = "catalog_changes"
+
+// Event is the entity of messages announcing that an outbox event was published, with the
+// outbox ID as the message ID. They carry no change of their own, so caches ignore them.
+const Event = "event"
+
+// PersistedQuery is the entity of messages announcing that a persisted query was registered.
+// Replicas reload the registered queries; caches ignore them.
+const PersistedQuery = "persisted_query"
+
+// Message identifies the entity that changed. An ID of zero means every entity of that type.
+type Message struct {
+	Entity string `json:"entity"`
+	ID     int    `json:"id,omitempty"`
+}
+
+// ParseMessage decodes a notification payload.
+func ParseMessage(payload string) (Message, error) {
+	var msg Message
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		return msg, err
+	}
+	if msg.Entity == "" {
+		return msg, errors.New("missing entity")
+	}
+	return msg, nil
+}
+
+// Invalidator is implemented by in-process caches that hold catalog data.
+type Invalidator interface {
+	Invalidate(msg Message)
+	InvalidateAll()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Notify announces a change to every replica. When called inside a transaction the
+// notification is only delivered if the transaction commits.
+func Notify(ctx context.Context, db execer, msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, `select pg_notify($1, $2)`, Channel, string(payload))
+	return err
+}
+
+// Hub fans invalidations out to the caches registered with it.
+type Hub struct {
+	mu           sync.RWMutex
+	invalidators []Invalidator
+}
+
+func NewHub() *Hub {
+	return &Hub{}
+}
+
+func (h *Hub) Register(invalidator Invalidator) {
+	h.mu.Lock()
+	h.invalidators = append(h.invalidators, invalidator)
+	h.mu.Unlock()
+}
+
+func (h *Hub) Invalidate(msg Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, invalidator := range h.invalidators {
+		invalidator.Invalidate(msg)
+	}
+}
+
+func (h *Hub) InvalidateAll() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, invalidator := range h.invalidators {
+		invalidator.InvalidateAll()
+	}
+}
+
+// Listener receives catalog change notifications on a dedicated connection and passes them
+// to the hub. Notifications sent while it is disconnected are lost, so every time it
+// (re)connects it invalidates everything.
+type Listener struct {
+	DSN          string
+	Hub          *Hub
+	PingInterval time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	// connect holds one connection until it fails; tests replace it.
+	connect func(ctx context.Context) (bool, error)
+}
+
+func NewListener(dsn string, hub *Hub) *Listener {
+	l := &Listener{
+		DSN:          dsn,
+		Hub:          hub,
+		PingInterval: time.Second * 30,
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Second * 30,
+	}
+	l.connect = l.listen
+	return l
+}
+
+// Run listens until ctx is done, reconnecting with exponential backoff.
+func (l *Listener) Run(ctx context.Context) {
+	backoff := l.MinBackoff
+	for {
+		connected, err := l.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("invalidation listener:", err)
+
+		if connected {
+			backoff = l.MinBackoff
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = l.nextBackoff(backoff)
+	}
+}
+
+// nextBackoff doubles backoff up to MaxBackoff.
+func (l *Listener) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > l.MaxBackoff {
+		return l.MaxBackoff
+	}
+	return backoff
+}
+
+// listen holds one connection until it fails. It reports whether the connection was
+// established so Run can reset its backoff.
+func (l *Listener) listen(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, l.DSN)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, "listen "+pgx.Identifier{Channel}.Sanitize())
+	if err != nil {
+		return false, err
+	}
+
+	l.Hub.InvalidateAll()
+
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, l.PingInterval)
+		notification, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			// nothing arrived; make sure the connection is still alive
+			if err = conn.Ping(ctx); err != nil {
+				return true, err
+			}
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+
+		l.handle(notification.Payload)
+	}
+}
+
+// handle passes a notification to the hub, invalidating everything when the payload cannot
+// be understood.
+func (l *Listener) handle(payload string) {
+	msg, err := ParseMessage(payload)
+	if err != nil {
+		log.Println("invalidation listener: invalid payload:", payload)
+		l.Hub.InvalidateAll()
+		return
+	}
+
+	l.Hub.Invalidate(msg)
+}
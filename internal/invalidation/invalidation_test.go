@@ -0,0 +1,139 @@
+﻿package invalidation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	messages []Message
+	all      int
+}
+
+func (r *recorder) Invalidate(msg Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+}
+
+func (r *recorder) InvalidateAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.all++
+}
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    Message
+		wantErr bool
+	}{
+		{payload: `{"entity":"movie","id":7}`, want: Message{Entity: "movie", ID: 7}},
+		{payload: `{"entity":"genre"}`, want: Message{Entity: "genre"}},
+		{payload: `{"entity":"event","id":42,"extra":true}`, want: Message{Entity: Event, ID: 42}},
+		{payload: `{"id":7}`, wantErr: true},
+		{payload: `movie:7`, wantErr: true},
+		{payload: ``, wantErr: true},
+	}
+
+	for _, test := range tests {
+		msg, err := ParseMessage(test.payload)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v", test.payload, err)
+			continue
+		}
+		if !test.wantErr && msg != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.payload, msg, test.want)
+		}
+	}
+}
+
+func TestHandleFallsBackToFullInvalidation(t *testing.T) {
+	hub := NewHub()
+	rec := &recorder{}
+	hub.Register(rec)
+	l := NewListener("", hub)
+
+	l.handle(`{"entity":"movie","id":3}`)
+	l.handle(`not json`)
+
+	if len(rec.messages) != 1 || rec.messages[0] != (Message{Entity: "movie", ID: 3}) {
+		t.Fatalf("got messages %+v", rec.messages)
+	}
+	if rec.all != 1 {
+		t.Fatalf("got %d full invalidations, want 1", rec.all)
+	}
+}
+
+func TestNextBackoff(t *testing.T) {
+	l := NewListener("", NewHub())
+	l.MaxBackoff = time.Second * 5
+
+	tests := []struct {
+		backoff, want time.Duration
+	}{
+		{time.Second, time.Second * 2},
+		{time.Second * 2, time.Second * 4},
+		{time.Second * 4, time.Second * 5},
+		{time.Second * 5, time.Second * 5},
+	}
+
+	for _, test := range tests {
+		if got := l.nextBackoff(test.backoff); got != test.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", test.backoff, got, test.want)
+		}
+	}
+}
+
+func TestRunReconnectsWithBackoff(t *testing.T) {
+	l := NewListener("", NewHub())
+	l.MinBackoff = time.Millisecond * 10
+	l.MaxBackoff = time.Millisecond * 80
+
+	// three failed connections, one that was established before failing, then one more failure
+	results := []bool{false, false, false, true, false}
+	var calls []time.Time
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.connect = func(ctx context.Context) (bool, error) {
+		calls = append(calls, time.Now())
+		connected := results[len(calls)-1]
+		if len(calls) == len(results) {
+			cancel()
+		}
+		return connected, errors.New("connection lost")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Run did not return after ctx was cancelled")
+	}
+
+	if len(calls) != len(results) {
+		t.Fatalf("got %d connection attempts, want %d", len(calls), len(results))
+	}
+
+	minimums := []time.Duration{l.MinBackoff, l.MinBackoff * 2, l.MinBackoff * 4, l.MinBackoff}
+	for i, minimum := range minimums {
+		if gap := calls[i+1].Sub(calls[i]); gap < minimum {
+			t.Errorf("attempt %d came %s after the previous one, want at least %s", i+2, gap, minimum)
+		}
+	}
+
+	// the backoff starts over once a connection was established
+	if gap := calls[4].Sub(calls[3]); gap >= l.MinBackoff*4 {
+		t.Errorf("backoff was not reset after a connection: waited %s", gap)
+	}
+}
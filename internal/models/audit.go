@@ -0,0 +1,61 @@
+﻿package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+type AuditEntry struct {
+	ID         int                    `json:"id"`
+	ActorID    int                    `json:"actor_id"`
+	Action     string                 `json:"action"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   int                    `json:"entity_id"`
+	Before     json.RawMessage        `json:"before,omitempty"`
+	After      json.RawMessage        `json:"after,omitempty"`
+	Diff       map[string]FieldChange `json:"diff,omitempty"`
+	RequestID  string                 `json:"request_id"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+type FieldChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+type AuditFilter struct {
+	EntityType string
+	EntityID   int
+	ActorID    int
+	Action     string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+// DiffJSON compares two JSON objects field by field and returns the fields whose values differ.
+func DiffJSON(before, after json.RawMessage) map[string]FieldChange {
+	var from, to map[string]json.RawMessage
+	if len(before) > 0 {
+		_ = json.Unmarshal(before, &from)
+	}
+	if len(after) > 0 {
+		_ = json.Unmarshal(after, &to)
+	}
+
+	diff := make(map[string]FieldChange)
+	for key, value := range from {
+		if other, ok := to[key]; !ok || !bytes.Equal(value, other) {
+			diff[key] = FieldChange{From: value, To: to[key]}
+		}
+	}
+	for key, value := range to {
+		if _, ok := from[key]; !ok {
+			diff[key] = FieldChange{To: value}
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,40 @@
+﻿package models
+
+import "time"
+
+// Collection is an ordered group of movies, such as a franchise.
+type Collection struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	Movies      []*Movie  `json:"movies,omitempty"`
+	MovieIDs    []int     `json:"movie_ids,omitempty"`
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+}
+
+// CollectionEntry places a movie in one of its collections, with the movies before and after it.
+type CollectionEntry struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Position int    `json:"position"`
+	Previous *Movie `json:"previous,omitempty"`
+	Next     *Movie `json:"next,omitempty"`
+}
+
+const (
+	RelationSequelOf = "sequel_of"
+	RelationRemakeOf = "remake_of"
+	RelationSpinOff  = "spin_off"
+)
+
+var RelationTypes = []string{RelationSequelOf, RelationRemakeOf, RelationSpinOff}
+
+// MovieRelation links a movie to a related one. A relation is stored once, from the sequel,
+// remake or spin-off to the original; seen from the original it is Inverse.
+type MovieRelation struct {
+	Type    string `json:"type"`
+	Inverse bool   `json:"inverse"`
+	Movie   *Movie `json:"movie"`
+}
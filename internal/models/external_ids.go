@@ -0,0 +1,56 @@
+﻿package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	imdbIDPattern     = regexp.MustCompile(`^tt[0-9]{7,10}$`)
+	wikidataIDPattern = regexp.MustCompile(`^Q[1-9][0-9]*$`)
+)
+
+// DuplicateTitle is the Duplicate reason for a movie with the same normalized title released
+// in the same year. Other reasons name the external ID that matched.
+const DuplicateTitle = "title_and_year"
+
+// Duplicate is an existing movie that a new one appears to duplicate, trashed movies included.
+type Duplicate struct {
+	Reason string `json:"reason"`
+	Movie  *Movie `json:"movie"`
+}
+
+// NormalizeExternalIDs trims the movie's external IDs, fixes the case of their prefixes and
+// checks their format.
+func NormalizeExternalIDs(movie *Movie) error {
+	movie.IMDbID = strings.ToLower(strings.TrimSpace(movie.IMDbID))
+	if movie.IMDbID != "" && !imdbIDPattern.MatchString(movie.IMDbID) {
+		return errors.New("imdb_id must look like tt0120737")
+	}
+
+	movie.WikidataID = strings.ToUpper(strings.TrimSpace(movie.WikidataID))
+	if movie.WikidataID != "" && !wikidataIDPattern.MatchString(movie.WikidataID) {
+		return errors.New("wikidata_id must look like Q127367")
+	}
+
+	if movie.TMDBID < 0 {
+		return errors.New("tmdb_id cannot be negative")
+	}
+
+	return nil
+}
+
+// KeepExternalIDs fills in any external ID missing from movie with the one from before, so a
+// client that does not know about them cannot clear them by leaving them out.
+func KeepExternalIDs(movie, before *Movie) {
+	if movie.IMDbID == "" {
+		movie.IMDbID = before.IMDbID
+	}
+	if movie.TMDBID == 0 {
+		movie.TMDBID = before.TMDBID
+	}
+	if movie.WikidataID == "" {
+		movie.WikidataID = before.WikidataID
+	}
+}
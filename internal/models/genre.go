@@ -3,9 +3,14 @@
 import "time"
 
 type Genre struct {
-	ID        int       `json:"id"`
-	Genre     string    `json:"genre"`
-	Checked   bool      `json:"checked"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	ID      int    `json:"id"`
+	Genre   string `json:"genre"`
+	Checked bool   `json:"checked"`
+	// ParentID is the genre this one is a subgenre of, if any.
+	ParentID *int `json:"parent_id,omitempty"`
+	// MovieCount is the number of movies outside the trash in the genre. It is only set when
+	// genres are listed or fetched on their own.
+	MovieCount int       `json:"movie_count"`
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"-"`
 }
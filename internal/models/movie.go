@@ -3,15 +3,24 @@
 import "time"
 
 type Movie struct {
-	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	ReleaseDate time.Time `json:"release_date"`
-	RunTime     int       `json:"run_time"`
-	MPAARating  string    `json:"mpaa_rating"`
-	Description string    `json:"description"`
-	Image       string    `json:"image"`
-	CreatedAt   time.Time `json:"-"`
-	UpdatedAt   time.Time `json:"-"`
-	Genres      []*Genre  `json:"genres,omitempty"`
-	GenresArray []int     `json:"genres_array,omitempty"`
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	ReleaseDate time.Time  `json:"release_date"`
+	RunTime     int        `json:"run_time"`
+	MPAARating  string     `json:"mpaa_rating"`
+	Description string     `json:"description"`
+	Image       string     `json:"image"`
+	IMDbID      string     `json:"imdb_id,omitempty"`
+	TMDBID      int        `json:"tmdb_id,omitempty"`
+	WikidataID  string     `json:"wikidata_id,omitempty"`
+	CreatedAt   time.Time  `json:"-"`
+	UpdatedAt   time.Time  `json:"-"`
+	Version     int        `json:"version"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Genres      []*Genre   `json:"genres,omitempty"`
+	GenresArray []int      `json:"genres_array,omitempty"`
+	Tags        []*Tag     `json:"tags,omitempty"`
+	// Collections and Relations are only set on a movie's detail response.
+	Collections []*CollectionEntry `json:"collections,omitempty"`
+	Relations   []*MovieRelation   `json:"relations,omitempty"`
 }
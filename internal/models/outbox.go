@@ -0,0 +1,33 @@
+﻿package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxEvent is a catalog event written in the same transaction as the change it describes.
+// Payload holds a snapshot of the entity after the change. DeliveredSinks names the sinks that
+// have already received the event; DeadAt is set once the relay gives up on it.
+type OutboxEvent struct {
+	ID             int64           `json:"id"`
+	EntityType     string          `json:"entity_type"`
+	EntityID       int             `json:"entity_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredSinks []string        `json:"delivered_sinks,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	PublishedAt    *time.Time      `json:"published_at,omitempty"`
+	DeadAt         *time.Time      `json:"dead_at,omitempty"`
+}
+
+// Delivered reports whether the named sink has already received the event.
+func (e *OutboxEvent) Delivered(sink string) bool {
+	for _, name := range e.DeliveredSinks {
+		if name == sink {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+﻿package models
+
+import "time"
+
+// MoviePageQuery selects one page of movies using keyset pagination over (SortField, id).
+type MoviePageQuery struct {
+	TitleContains string
+	// GenreID selects movies in the genre or any of its subgenres.
+	GenreID int
+	// Tags selects movies that have every listed tag, ignoring case.
+	Tags           []string
+	MPAARating     string
+	ReleasedAfter  time.Time
+	ReleasedBefore time.Time
+	SortField      string
+	Descending     bool
+	After          *MovieCursor
+	Before         *MovieCursor
+	Limit          int
+	// Backward selects the last Limit movies before Before instead of the first after After.
+	Backward bool
+}
+
+// MovieCursor identifies a position in a sorted movie list by the sort column value and id.
+type MovieCursor struct {
+	SortField string `json:"f"`
+	Value     string `json:"v"`
+	ID        int    `json:"id"`
+}
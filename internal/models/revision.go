@@ -0,0 +1,12 @@
+﻿package models
+
+import "time"
+
+type MovieRevision struct {
+	ID        int       `json:"id"`
+	MovieID   int       `json:"movie_id"`
+	Revision  int       `json:"revision"`
+	Snapshot  *Movie    `json:"snapshot,omitempty"`
+	ActorID   int       `json:"actor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,46 @@
+﻿package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxTagLength    = 64
+	MaxTagsPerMovie = 50
+)
+
+type Tag struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	MovieCount int       `json:"movie_count"`
+	CreatedAt  time.Time `json:"-"`
+	UpdatedAt  time.Time `json:"-"`
+}
+
+// NormalizeTags trims and collapses the whitespace in each tag name and drops empty names and
+// names that differ from an earlier one only in case.
+func NormalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.Join(strings.Fields(name), " ")
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		if utf8.RuneCountInString(name) > MaxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", name, MaxTagLength)
+		}
+
+		seen[strings.ToLower(name)] = true
+		normalized = append(normalized, name)
+	}
+
+	if len(normalized) > MaxTagsPerMovie {
+		return nil, fmt.Errorf("a movie can have at most %d tags", MaxTagsPerMovie)
+	}
+
+	return normalized, nil
+}
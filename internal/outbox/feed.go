@@ -0,0 +1,64 @@
+﻿package outbox
+
+import (
+	"context"
+	"log"
+	"movie-library/internal/events"
+	"movie-library/internal/invalidation"
+	"movie-library/internal/repository"
+	"time"
+)
+
+// Feed publishes events relayed by any replica on the local bus. Each event is relayed once,
+// by whichever replica claims it, so the relay's bus sink announces the event with NOTIFY and
+// the Feed registered with every replica's hub loads and publishes it. SSE and subscription
+// clients then see every event whichever replica they are connected to.
+type Feed struct {
+	DB  repository.DatabaseRepo
+	Bus *events.Bus
+}
+
+func NewFeed(db repository.DatabaseRepo, bus *events.Bus) *Feed {
+	return &Feed{DB: db, Bus: bus}
+}
+
+// Sink returns the relay sink that announces events to every replica.
+func (f *Feed) Sink() Sink {
+	return Sink{Name: "bus", Deliver: f.announce}
+}
+
+func (f *Feed) announce(event events.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return invalidation.Notify(ctx, f.DB.Connection(), invalidation.Message{Entity: invalidation.Event, ID: int(event.ID)})
+}
+
+// Invalidate publishes the announced event on the bus and ignores catalog changes.
+func (f *Feed) Invalidate(msg invalidation.Message) {
+	if msg.Entity != invalidation.Event {
+		return
+	}
+
+	record, err := f.DB.GetOutboxEvent(int64(msg.ID))
+	if err != nil {
+		log.Printf("outbox feed: event %d: %v", msg.ID, err)
+		f.Bus.MarkGap()
+		return
+	}
+
+	event, err := Event(record)
+	if err != nil {
+		log.Printf("outbox feed: event %d: %v", msg.ID, err)
+		f.Bus.MarkGap()
+		return
+	}
+
+	f.Bus.Publish(event)
+}
+
+// InvalidateAll is called when announcements may have been missed, so clients resuming from an
+// earlier event are told to reload.
+func (f *Feed) InvalidateAll() {
+	f.Bus.MarkGap()
+}
@@ -0,0 +1,214 @@
+﻿package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"movie-library/internal/events"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"time"
+)
+
+// Sink receives events relayed from the outbox. A sink returning an error causes the event
+// to be retried later on the sinks that have not received it yet. A relay that stops midway
+// through an event delivers it to every sink again, so sinks must tolerate duplicates.
+type Sink struct {
+	Name    string
+	Deliver func(event events.Event) error
+}
+
+// Relay publishes outbox events to its sinks at least once. Events are relayed in the order
+// they were written; when an event fails, later events for the same entity are held back
+// until it succeeds so each entity's events stay in order. Failed events are retried with
+// exponential backoff and dead-lettered after MaxAttempts, after which the entity's later
+// events go ahead.
+//
+// Relays on several replicas share the outbox: each claims a batch by leasing it for Lease,
+// which must be longer than a batch takes to deliver.
+type Relay struct {
+	DB           repository.DatabaseRepo
+	Sinks        []Sink
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// Retention is how long published events are kept before they are deleted.
+	Retention time.Duration
+	wake      chan struct{}
+}
+
+func NewRelay(db repository.DatabaseRepo, sinks ...Sink) *Relay {
+	return &Relay{
+		DB:           db,
+		Sinks:        sinks,
+		BatchSize:    100,
+		PollInterval: time.Second * 5,
+		Lease:        time.Minute,
+		MaxAttempts:  10,
+		BaseDelay:    time.Second * 5,
+		MaxDelay:     time.Minute * 30,
+		Retention:    time.Hour * 24 * 7,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake makes the relay check the outbox straight away instead of waiting for the next poll.
+func (r *Relay) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	for {
+		r.RelayPending()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		case <-cleanup.C:
+			deleted, err := r.DB.DeletePublishedOutboxEvents(time.Now().Add(-r.Retention))
+			if err != nil {
+				log.Println("outbox:", err)
+			} else if deleted > 0 {
+				log.Printf("outbox: deleted %d published events", deleted)
+			}
+		}
+	}
+}
+
+// RelayPending claims and delivers pending events until no more can be claimed. Failed events
+// stay leased until their next retry, so each claim moves on to later events.
+func (r *Relay) RelayPending() {
+	blocked := make(map[string]bool)
+	for {
+		pending, err := r.DB.ClaimOutboxEvents(r.BatchSize, time.Now().Add(r.Lease))
+		if err != nil {
+			log.Println("outbox:", err)
+			return
+		}
+
+		for _, record := range pending {
+			// an earlier event for the entity failed; this one stays leased and is claimed
+			// again once the lease expires and the earlier event has gone through
+			entity := fmt.Sprintf("%s:%d", record.EntityType, record.EntityID)
+			if blocked[entity] {
+				continue
+			}
+
+			err = r.relay(record)
+			if err != nil {
+				blocked[entity] = true
+				r.fail(record, err)
+				continue
+			}
+
+			err = r.DB.MarkOutboxEventPublished(record.ID)
+			if err != nil {
+				log.Println("outbox:", err)
+				return
+			}
+		}
+
+		if len(pending) < r.BatchSize {
+			return
+		}
+	}
+}
+
+// relay delivers an event to the sinks that have not received it yet, recording each sink
+// that succeeds.
+func (r *Relay) relay(record *models.OutboxEvent) error {
+	event, err := Event(record)
+	if err != nil {
+		return err
+	}
+
+	for _, sink := range r.Sinks {
+		if record.Delivered(sink.Name) {
+			continue
+		}
+
+		err = sink.Deliver(event)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sink.Name, err)
+		}
+		record.DeliveredSinks = append(record.DeliveredSinks, sink.Name)
+	}
+
+	return nil
+}
+
+// fail records a failed attempt, scheduling a retry or dead-lettering the event once it has
+// failed MaxAttempts times.
+func (r *Relay) fail(record *models.OutboxEvent, err error) {
+	record.Attempts++
+	record.LastError = err.Error()
+
+	now := time.Now()
+	if record.Attempts >= r.MaxAttempts {
+		record.DeadAt = &now
+		log.Printf("outbox: event %d dead-lettered after %d attempts: %v", record.ID, record.Attempts, err)
+	} else {
+		log.Printf("outbox: event %d: %v", record.ID, err)
+	}
+
+	err = r.DB.RecordOutboxEventFailure(*record, now.Add(r.Backoff(record.Attempts)))
+	if err != nil {
+		log.Println("outbox:", err)
+	}
+}
+
+// Backoff returns the delay before the next attempt after the given number of failed attempts.
+func (r *Relay) Backoff(attempts int) time.Duration {
+	delay := r.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= r.MaxDelay {
+			return r.MaxDelay
+		}
+	}
+
+	return delay
+}
+
+// Event converts an outbox record into the event delivered to sinks. The event ID is the
+// outbox ID, so it is stable across redeliveries.
+func Event(record *models.OutboxEvent) (events.Event, error) {
+	event := events.Event{
+		ID:         uint64(record.ID),
+		Type:       record.EventType,
+		EntityID:   record.EntityID,
+		OccurredAt: record.CreatedAt.UTC(),
+	}
+
+	var err error
+	switch record.EntityType {
+	case "movie":
+		event.Movie = &models.Movie{}
+		err = json.Unmarshal(record.Payload, event.Movie)
+	case "genre":
+		event.Genre = &models.Genre{}
+		err = json.Unmarshal(record.Payload, event.Genre)
+	case "collection":
+		event.Collection = &models.Collection{}
+		err = json.Unmarshal(record.Payload, event.Collection)
+	default:
+		err = fmt.Errorf("unknown entity type %q", record.EntityType)
+	}
+
+	return event, err
+}
@@ -0,0 +1,23 @@
+﻿package repository
+
+import "context"
+
+// Actor identifies who makes a change and in which request. Repository writes record it in
+// the audit log, in the same transaction as the change.
+type Actor struct {
+	ID        int
+	RequestID string
+}
+
+type actorKey struct{}
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor attached to ctx, or the zero Actor for changes made by
+// the system itself, such as the scheduled trash purge.
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
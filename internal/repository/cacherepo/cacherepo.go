@@ -0,0 +1,243 @@
+﻿package cacherepo
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sync/singleflight"
+	"movie-library/internal/invalidation"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	genresKey = "genres"
+	moviesKey = "movies:"
+)
+
+// CachedRepo is a read-through cache in front of another DatabaseRepo. Genres and movie lists
+// are served from the store; every other method goes straight to the wrapped repository.
+// Writes made through CachedRepo, and changes announced by other replicas, invalidate the
+// cached lists.
+type CachedRepo struct {
+	repository.DatabaseRepo
+
+	store Store
+	group singleflight.Group
+	// generation is bumped on every invalidation so a load that started before it is not cached.
+	generation atomic.Uint64
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+}
+
+func New(repo repository.DatabaseRepo, ttl time.Duration, maxEntries int) *CachedRepo {
+	c := &CachedRepo{DatabaseRepo: repo}
+
+	store := NewMemoryStore(ttl, maxEntries)
+	store.OnEvict = func() { c.evictions.Add(1) }
+	c.store = store
+
+	return c
+}
+
+func (c *CachedRepo) Stats() Stats {
+	return Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       c.store.Len(),
+	}
+}
+
+// load returns the cached value for key, collapsing concurrent misses into a single call to fetch.
+func (c *CachedRepo) load(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	if value, ok := c.store.Get(key); ok {
+		c.hits.Add(1)
+		return value, nil
+	}
+	c.misses.Add(1)
+
+	value, err, _ := c.group.Do(key, func() (interface{}, error) {
+		generation := c.generation.Load()
+
+		value, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.generation.Load() == generation {
+			c.store.Set(key, value)
+		}
+		return value, nil
+	})
+
+	return value, err
+}
+
+func (c *CachedRepo) Genres() ([]*models.Genre, error) {
+	value, err := c.load(genresKey, func() (interface{}, error) {
+		return c.DatabaseRepo.Genres()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return copyGenres(value.([]*models.Genre)), nil
+}
+
+func (c *CachedRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
+	value, err := c.load(fmt.Sprint(moviesKey, genre), func() (interface{}, error) {
+		return c.DatabaseRepo.AllMovies(genre...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return copyMovies(value.([]*models.Movie)), nil
+}
+
+// Callers get their own copies so changes they make to the results cannot leak into the cache.
+func copyGenres(genres []*models.Genre) []*models.Genre {
+	copied := make([]*models.Genre, len(genres))
+	for i, genre := range genres {
+		g := *genre
+		copied[i] = &g
+	}
+	return copied
+}
+
+func copyMovies(movies []*models.Movie) []*models.Movie {
+	copied := make([]*models.Movie, len(movies))
+	for i, movie := range movies {
+		m := *movie
+		copied[i] = &m
+	}
+	return copied
+}
+
+// Invalidate implements invalidation.Invalidator. Movie lists embed genres and the genre list
+// counts movies, so any change drops everything.
+func (c *CachedRepo) Invalidate(msg invalidation.Message) {
+	if msg.Entity == invalidation.Event || msg.Entity == invalidation.PersistedQuery {
+		return
+	}
+	c.InvalidateAll()
+}
+
+func (c *CachedRepo) InvalidateAll() {
+	c.generation.Add(1)
+	c.invalidations.Add(1)
+	c.store.Clear()
+}
+
+func (c *CachedRepo) invalidateMovie(id int) {
+	c.Invalidate(invalidation.Message{Entity: "movie", ID: id})
+}
+
+func (c *CachedRepo) CreateGenre(ctx context.Context, genre string, parentID *int) (int, error) {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.CreateGenre(ctx, genre, parentID)
+}
+
+func (c *CachedRepo) UpdateGenre(ctx context.Context, id int, name string, parentID *int) error {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.UpdateGenre(ctx, id, name, parentID)
+}
+
+func (c *CachedRepo) MergeGenres(ctx context.Context, sourceID, targetID int) ([]int, error) {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.MergeGenres(ctx, sourceID, targetID)
+}
+
+func (c *CachedRepo) DeleteGenre(ctx context.Context, id int) error {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.DeleteGenre(ctx, id)
+}
+
+func (c *CachedRepo) CreateMovie(ctx context.Context, movie models.Movie) (int, error) {
+	defer c.invalidateMovie(0)
+	return c.DatabaseRepo.CreateMovie(ctx, movie)
+}
+
+func (c *CachedRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	defer c.invalidateMovie(movie.ID)
+	return c.DatabaseRepo.UpdateMovie(ctx, movie)
+}
+
+func (c *CachedRepo) RevertMovie(ctx context.Context, movie models.Movie) error {
+	defer c.invalidateMovie(movie.ID)
+	return c.DatabaseRepo.RevertMovie(ctx, movie)
+}
+
+func (c *CachedRepo) PatchMovie(ctx context.Context, id, version int, changes map[string]interface{}, genreIDs []int) error {
+	defer c.invalidateMovie(id)
+	return c.DatabaseRepo.PatchMovie(ctx, id, version, changes, genreIDs)
+}
+
+func (c *CachedRepo) CreateMovieGenre(ctx context.Context, id int, genreIDs []int) error {
+	defer c.invalidateMovie(id)
+	return c.DatabaseRepo.CreateMovieGenre(ctx, id, genreIDs)
+}
+
+func (c *CachedRepo) SetMovieTags(ctx context.Context, id int, names []string) error {
+	defer c.invalidateMovie(id)
+	return c.DatabaseRepo.SetMovieTags(ctx, id, names)
+}
+
+func (c *CachedRepo) DeleteMovie(ctx context.Context, id int) error {
+	defer c.invalidateMovie(id)
+	return c.DatabaseRepo.DeleteMovie(ctx, id)
+}
+
+func (c *CachedRepo) RestoreMovie(ctx context.Context, id int) error {
+	defer c.invalidateMovie(id)
+	return c.DatabaseRepo.RestoreMovie(ctx, id)
+}
+
+func (c *CachedRepo) PurgeMovie(ctx context.Context, id int) error {
+	defer c.invalidateMovie(id)
+	return c.DatabaseRepo.PurgeMovie(ctx, id)
+}
+
+func (c *CachedRepo) PurgeDeletedMovies(ctx context.Context, deletedBefore time.Time) ([]int, error) {
+	defer c.invalidateMovie(0)
+	return c.DatabaseRepo.PurgeDeletedMovies(ctx, deletedBefore)
+}
+
+func (c *CachedRepo) InsertCollection(ctx context.Context, collection models.Collection) (int, error) {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.InsertCollection(ctx, collection)
+}
+
+func (c *CachedRepo) UpdateCollection(ctx context.Context, collection models.Collection) error {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.UpdateCollection(ctx, collection)
+}
+
+func (c *CachedRepo) DeleteCollection(ctx context.Context, id int) error {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.DeleteCollection(ctx, id)
+}
+
+func (c *CachedRepo) AddMovieRelation(ctx context.Context, movieID, relatedID int, relation string) error {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.AddMovieRelation(ctx, movieID, relatedID, relation)
+}
+
+func (c *CachedRepo) RemoveMovieRelation(ctx context.Context, movieID, relatedID int, relation string) error {
+	defer c.InvalidateAll()
+	return c.DatabaseRepo.RemoveMovieRelation(ctx, movieID, relatedID, relation)
+}
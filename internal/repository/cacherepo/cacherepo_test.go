@@ -0,0 +1,136 @@
+﻿package cacherepo
+
+import (
+	"context"
+	"movie-library/internal/invalidation"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingRepo struct {
+	repository.DatabaseRepo
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (r *countingRepo) AllMovies(genre ...int) ([]*models.Movie, error) {
+	r.calls.Add(1)
+	if r.release != nil {
+		<-r.release
+	}
+	return []*models.Movie{{ID: 1, Title: "Highlander"}}, nil
+}
+
+func (r *countingRepo) DeleteMovie(ctx context.Context, id int) error {
+	return nil
+}
+
+func (r *countingRepo) DeleteCollection(ctx context.Context, id int) error {
+	return nil
+}
+
+func (r *countingRepo) AddMovieRelation(ctx context.Context, movieID, relatedID int, relation string) error {
+	return nil
+}
+
+func TestCachedRepoServesHitsAndInvalidatesOnWrite(t *testing.T) {
+	db := &countingRepo{}
+	c := New(db, time.Minute, 10)
+
+	for i := 0; i < 3; i++ {
+		movies, err := c.AllMovies()
+		if err != nil || len(movies) != 1 {
+			t.Fatalf("got %v, %v", movies, err)
+		}
+		movies[0].Title = "changed by caller"
+	}
+
+	if db.calls.Load() != 1 {
+		t.Fatalf("expected 1 database call, got %d", db.calls.Load())
+	}
+
+	movies, _ := c.AllMovies()
+	if movies[0].Title != "Highlander" {
+		t.Fatal("a caller's change leaked into the cache")
+	}
+
+	_ = c.DeleteMovie(context.Background(), 1)
+	_, _ = c.AllMovies()
+	if db.calls.Load() != 2 {
+		t.Fatalf("expected the write to invalidate the cache, got %d database calls", db.calls.Load())
+	}
+
+	c.Invalidate(invalidation.Message{Entity: "movie", ID: 1})
+	_, _ = c.AllMovies()
+
+	stats := c.Stats()
+	if stats.Hits != 3 || stats.Misses != 3 || stats.Invalidations != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCachedRepoInvalidatesOnCollectionAndRelationWrites(t *testing.T) {
+	db := &countingRepo{}
+	c := New(db, time.Minute, 10)
+
+	writes := map[string]func() error{
+		"DeleteCollection": func() error { return c.DeleteCollection(context.Background(), 1) },
+		"AddMovieRelation": func() error { return c.AddMovieRelation(context.Background(), 1, 2, "sequel") },
+	}
+	for name, write := range writes {
+		_, _ = c.AllMovies()
+		before := db.calls.Load()
+
+		_ = write()
+		_, _ = c.AllMovies()
+		if db.calls.Load() != before+1 {
+			t.Errorf("%s did not invalidate the cache", name)
+		}
+	}
+}
+
+func TestCachedRepoCollapsesConcurrentMisses(t *testing.T) {
+	db := &countingRepo{release: make(chan struct{})}
+	c := New(db, time.Minute, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = c.AllMovies(2)
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	close(db.release)
+	wg.Wait()
+
+	if db.calls.Load() != 1 {
+		t.Fatalf("expected concurrent misses to share 1 database call, got %d", db.calls.Load())
+	}
+}
+
+func TestMemoryStoreLimits(t *testing.T) {
+	store := NewMemoryStore(time.Millisecond*20, 2)
+	evicted := 0
+	store.OnEvict = func() { evicted++ }
+
+	store.Set("a", 1)
+	store.Set("b", 2)
+	store.Get("a")
+	store.Set("c", 3)
+
+	if _, ok := store.Get("b"); ok || evicted != 1 {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	if _, ok := store.Get("a"); ok {
+		t.Fatal("expected the entry to expire")
+	}
+}
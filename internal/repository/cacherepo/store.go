@@ -0,0 +1,104 @@
+﻿package cacherepo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Store holds cached values. The in-memory store is the only backend for now.
+type Store interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Clear()
+	Len() int
+}
+
+type memoryEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// MemoryStore is an LRU cache whose entries also expire after a fixed TTL.
+type MemoryStore struct {
+	TTL        time.Duration
+	MaxEntries int
+	// OnEvict is called when an entry is dropped to make room for another.
+	OnEvict func()
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func NewMemoryStore(ttl time.Duration, maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		TTL:        ttl,
+		MaxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		s.remove(element)
+		return nil, false
+	}
+
+	s.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (s *MemoryStore) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := time.Now().Add(s.TTL)
+	if element, ok := s.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value = value
+		entry.expires = expires
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+
+	for s.MaxEntries > 0 && s.order.Len() > s.MaxEntries {
+		s.remove(s.order.Back())
+		if s.OnEvict != nil {
+			s.OnEvict()
+		}
+	}
+}
+
+func (s *MemoryStore) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make(map[string]*list.Element)
+	s.order.Init()
+}
+
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
+
+func (s *MemoryStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryEntry).key)
+}
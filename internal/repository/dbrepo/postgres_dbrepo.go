@@ -3,10 +3,18 @@
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/jackc/pgconn"
 	"log"
+	"movie-library/internal/events"
+	"movie-library/internal/invalidation"
 	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -22,7 +30,12 @@ func (m *PostgresDBRepo) Genres() ([]*models.Genre, error) {
 	defer cancel()
 
 	var genres []*models.Genre
-	query := `select id, genre, created_at, updated_at from genres order by genres`
+	query := `select g.id, g.genre, g.parent_id, g.created_at, g.updated_at, count(m.id)
+from genres g
+left join movies_genres mg on (mg.genre_id = g.id)
+left join movies m on (m.id = mg.movie_id and m.deleted_at is null)
+group by g.id
+order by g.id`
 	rows, err := m.DB.QueryContext(ctx, query)
 
 	if err != nil {
@@ -33,7 +46,7 @@ func (m *PostgresDBRepo) Genres() ([]*models.Genre, error) {
 
 	for rows.Next() {
 		var genre models.Genre
-		err := rows.Scan(&genre.ID, &genre.Genre, &genre.CreatedAt, &genre.UpdatedAt)
+		err := rows.Scan(&genre.ID, &genre.Genre, &genre.ParentID, &genre.CreatedAt, &genre.UpdatedAt, &genre.MovieCount)
 		if err != nil {
 			return nil, err
 		}
@@ -48,176 +61,2701 @@ func (m *PostgresDBRepo) Genres() ([]*models.Genre, error) {
 	return genres, nil
 }
 
+func (m *PostgresDBRepo) CreateGenre(ctx context.Context, genre string, parentID *int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newId int
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		err := checkGenreName(ctx, tx, genre, 0)
+		if err != nil {
+			return err
+		}
+
+		err = checkGenreParent(ctx, tx, 0, parentID)
+		if err != nil {
+			return err
+		}
+
+		query := `insert into genres (genre, parent_id, created_at, updated_at) values ($1, $2, $3, $4) returning id`
+		err = tx.QueryRowContext(ctx, query, genre, parentID, time.Now(), time.Now()).Scan(&newId)
+		if err != nil {
+			return genreNameConflict(err)
+		}
+
+		err = enqueueEvent(ctx, tx, events.GenreCreated, "genre", newId, models.Genre{ID: newId, Genre: genre, ParentID: parentID})
+		if err != nil {
+			return err
+		}
+
+		after, err := genreSnapshot(ctx, tx, newId)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "create", "genre", newId, nil, after)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (m *PostgresDBRepo) GetGenre(id int) (*models.Genre, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select g.id, g.genre, g.parent_id, g.created_at, g.updated_at,
+(select count(*) from movies_genres mg join movies m on (m.id = mg.movie_id) where mg.genre_id = g.id and m.deleted_at is null)
+from genres g where g.id = $1`
+	var genre models.Genre
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&genre.ID, &genre.Genre, &genre.ParentID, &genre.CreatedAt, &genre.UpdatedAt, &genre.MovieCount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genre, nil
+}
+
+// genreSnapshot loads a genre with its movie count as it stands inside tx, for the audit log,
+// and locks its row until tx ends.
+func genreSnapshot(ctx context.Context, tx *sql.Tx, id int) (*models.Genre, error) {
+	query := `select g.id, g.genre, g.parent_id, g.created_at, g.updated_at,
+(select count(*) from movies_genres mg join movies m on (m.id = mg.movie_id) where mg.genre_id = g.id and m.deleted_at is null)
+from genres g where g.id = $1 for update`
+	var genre models.Genre
+	err := tx.QueryRowContext(ctx, query, id).Scan(&genre.ID, &genre.Genre, &genre.ParentID, &genre.CreatedAt, &genre.UpdatedAt, &genre.MovieCount)
+	if err != nil {
+		return nil, err
+	}
+
+	return &genre, nil
+}
+
+// checkGenreName returns ErrGenreExists if another genre already has the name, ignoring case.
+func checkGenreName(ctx context.Context, tx *sql.Tx, name string, id int) error {
+	var exists bool
+	query := `select exists(select 1 from genres where lower(genre) = lower($1) and id <> $2)`
+	err := tx.QueryRowContext(ctx, query, name, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return repository.ErrGenreExists
+	}
+
+	return nil
+}
+
+// genreNameConflict translates a unique violation (SQLSTATE 23505) on the case-insensitive
+// genre name index, which a concurrent write can hit after checkGenreName, into ErrGenreExists.
+func genreNameConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "genres_lower_genre_idx" {
+		return repository.ErrGenreExists
+	}
+	return err
+}
+
+// genreSubtree returns a query selecting the ID of the genre bound to placeholder and the IDs
+// of all its descendants.
+func genreSubtree(placeholder string) string {
+	return fmt.Sprintf(`with recursive subtree (id) as (
+	select id from genres where id = %s
+	union
+	select g.id from genres g join subtree s on (g.parent_id = s.id)
+) select id from subtree`, placeholder)
+}
+
+// checkGenreParent returns ErrParentGenreNotFound if parentID does not exist, and ErrGenreCycle if
+// it is the genre itself or one of its descendants.
+func checkGenreParent(ctx context.Context, tx *sql.Tx, id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+
+	var exists, descendant bool
+	query := `select exists(select 1 from genres where id = $1), $1 in (` + genreSubtree("$2") + `)`
+	err := tx.QueryRowContext(ctx, query, *parentID, id).Scan(&exists, &descendant)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return repository.ErrParentGenreNotFound
+	}
+	if descendant {
+		return repository.ErrGenreCycle
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) UpdateGenre(ctx context.Context, id int, name string, parentID *int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := genreSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		err = checkGenreName(ctx, tx, name, id)
+		if err != nil {
+			return err
+		}
+
+		err = checkGenreParent(ctx, tx, id, parentID)
+		if err != nil {
+			return err
+		}
+
+		query := `update genres set genre = $1, parent_id = $2, updated_at = $3 where id = $4`
+		_, err = tx.ExecContext(ctx, query, name, parentID, time.Now(), id)
+		if err != nil {
+			return genreNameConflict(err)
+		}
+
+		// movies embed their genres' names, so their representations changed too
+		query = `update movies set updated_at = $1, version = version + 1 where id in (select movie_id from movies_genres where genre_id = $2)`
+		_, err = tx.ExecContext(ctx, query, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		err = enqueueEvent(ctx, tx, events.GenreUpdated, "genre", id, models.Genre{ID: id, Genre: name, ParentID: parentID})
+		if err != nil {
+			return err
+		}
+
+		after, err := genreSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "update", "genre", id, before, after)
+	})
+}
+
+// MergeGenres moves every movie and subgenre in the source genre to the target genre and
+// deletes the source. It returns the IDs of the movies whose genres changed.
+func (m *PostgresDBRepo) MergeGenres(ctx context.Context, sourceID, targetID int) ([]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	if sourceID == targetID {
+		return nil, errors.New("cannot merge a genre into itself")
+	}
+
+	var movieIDs []int
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		var source, target models.Genre
+		query := `select id, genre, parent_id from genres where id = any($1) order by id for update`
+		rows, err := tx.QueryContext(ctx, query, []int{sourceID, targetID})
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var genre models.Genre
+			if err := rows.Scan(&genre.ID, &genre.Genre, &genre.ParentID); err != nil {
+				rows.Close()
+				return err
+			}
+			if genre.ID == sourceID {
+				source = genre
+			} else {
+				target = genre
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if source.ID == 0 || target.ID == 0 {
+			return sql.ErrNoRows
+		}
+
+		sourceBefore, err := genreSnapshot(ctx, tx, sourceID)
+		if err != nil {
+			return err
+		}
+
+		targetBefore, err := genreSnapshot(ctx, tx, targetID)
+		if err != nil {
+			return err
+		}
+
+		// the source's subgenres move under the target, which must not be one of them
+		err = checkGenreParent(ctx, tx, sourceID, &targetID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `update genres set parent_id = $1, updated_at = $2 where parent_id = $3`, targetID, time.Now(), sourceID)
+		if err != nil {
+			return err
+		}
+
+		rows, err = tx.QueryContext(ctx, `select movie_id from movies_genres where genre_id = $1 order by movie_id`, sourceID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			movieIDs = append(movieIDs, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		query = `insert into movies_genres (movie_id, genre_id)
+select movie_id, $2 from movies_genres mg
+where mg.genre_id = $1 and not exists (select 1 from movies_genres where movie_id = mg.movie_id and genre_id = $2)`
+		_, err = tx.ExecContext(ctx, query, sourceID, targetID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from movies_genres where genre_id = $1`, sourceID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from genres where id = $1`, sourceID)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range movieIDs {
+			_, err = tx.ExecContext(ctx, `update movies set updated_at = $1, version = version + 1 where id = $2`, time.Now(), id)
+			if err != nil {
+				return err
+			}
+
+			_, err = enqueueMovieEvent(ctx, tx, events.MovieUpdated, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = enqueueEvent(ctx, tx, events.GenreDeleted, "genre", sourceID, source)
+		if err != nil {
+			return err
+		}
+
+		err = enqueueEvent(ctx, tx, events.GenreUpdated, "genre", targetID, target)
+		if err != nil {
+			return err
+		}
+
+		targetAfter, err := genreSnapshot(ctx, tx, targetID)
+		if err != nil {
+			return err
+		}
+
+		err = recordAudit(ctx, tx, "delete", "genre", sourceID, sourceBefore, nil)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "merge", "genre", targetID, targetBefore, targetAfter)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return movieIDs, nil
+}
+
+// DeleteGenre removes a genre that has no subgenres and that no movie uses, including movies in
+// the trash. It returns ErrGenreHasSubgenres or ErrGenreInUse otherwise.
+func (m *PostgresDBRepo) DeleteGenre(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := genreSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		var hasSubgenres bool
+		err = tx.QueryRowContext(ctx, `select exists(select 1 from genres where parent_id = $1)`, id).Scan(&hasSubgenres)
+		if err != nil {
+			return err
+		}
+
+		if hasSubgenres {
+			return repository.ErrGenreHasSubgenres
+		}
+
+		var inUse bool
+		err = tx.QueryRowContext(ctx, `select exists(select 1 from movies_genres where genre_id = $1)`, id).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			return repository.ErrGenreInUse
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from genres where id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		err = enqueueEvent(ctx, tx, events.GenreDeleted, "genre", id, models.Genre{ID: before.ID, Genre: before.Genre, ParentID: before.ParentID})
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "delete", "genre", id, before, nil)
+	})
+}
+
+func (m *PostgresDBRepo) GenresForMovies(movieIDs []int) (map[int][]*models.Genre, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select mg.movie_id, g.id, g.genre, g.parent_id from movies_genres mg join genres g on (mg.genre_id = g.id)
+where mg.movie_id = any($1) order by g.genre`
+	rows, err := m.DB.QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := make(map[int][]*models.Genre)
+	for rows.Next() {
+		var movieID int
+		var genre models.Genre
+		err := rows.Scan(&movieID, &genre.ID, &genre.Genre, &genre.ParentID)
+		if err != nil {
+			return nil, err
+		}
+
+		genre.Checked = true
+		genres[movieID] = append(genres[movieID], &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+func (m *PostgresDBRepo) MoviesForGenres(genreIDs []int, limit, offset int) (map[int][]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `with recursive subtree (root, id) as (
+	select id, id from genres where id = any($1)
+	union
+	select s.root, g.id from genres g join subtree s on (g.parent_id = s.id)
+)
+select genre_id, id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from (
+	select member.root as genre_id, mv.*, row_number() over (partition by member.root order by mv.title, mv.id) as position
+	from (select distinct s.root, mg.movie_id from subtree s join movies_genres mg on (mg.genre_id = s.id)) member
+	join movies mv on (member.movie_id = mv.id)
+	where mv.deleted_at is null
+) ranked
+where position > $2 and position <= $2 + $3
+order by genre_id, position`
+	rows, err := m.DB.QueryContext(ctx, query, genreIDs, offset, limit)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := make(map[int][]*models.Movie)
+	for rows.Next() {
+		var genreID int
+		var movie models.Movie
+		err := rows.Scan(&genreID, &movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version,
+			&movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+		if err != nil {
+			return nil, err
+		}
+
+		movies[genreID] = append(movies[genreID], &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
 func (m *PostgresDBRepo) AllMovies(genres ...int) ([]*models.Movie, error) {
 
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var movies []*models.Movie
+	where := "where deleted_at is null"
+	var args []interface{}
+	if len(genres) > 0 {
+		where += " and id in (select movie_id from movies_genres where genre_id in (" + genreSubtree("$1") + "))"
+		args = append(args, genres[0])
+	}
+	query := fmt.Sprintf(`select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '') from movies %s order by title`, where)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie models.Movie
+		err := rows.Scan(&movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version,
+			&movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err != nil {
+		return movies, err
+	}
+
+	return movies, nil
+}
+
+func (m *PostgresDBRepo) SearchMovies(title string) ([]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from movies where deleted_at is null and title ilike $1 order by title`
+	rows, err := m.DB.QueryContext(ctx, query, "%"+likeEscaper.Replace(title)+"%")
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMovies(rows)
+}
+
+func (m *PostgresDBRepo) MoviesByIDs(ids []int) ([]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from movies where deleted_at is null and id = any($1)`
+	rows, err := m.DB.QueryContext(ctx, query, ids)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMovies(rows)
+}
+
+// movieSortColumns maps the sortable fields to their column and the type used to compare cursor values.
+var movieSortColumns = map[string][2]string{
+	"title":        {"title", "text"},
+	"release_date": {"release_date", "timestamp"},
+	"created_at":   {"created_at", "timestamp"},
+	"run_time":     {"runtime", "integer"},
+}
+
+func (m *PostgresDBRepo) MoviesPage(page models.MoviePageQuery) ([]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if page.SortField == "" {
+		page.SortField = "title"
+	}
+	sortColumn, ok := movieSortColumns[page.SortField]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort movies by %s", page.SortField)
+	}
+
+	conditions := []string{"deleted_at is null"}
+	var args []interface{}
+	addCondition := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
+
+	if page.TitleContains != "" {
+		addCondition("title ilike $%d", "%"+likeEscaper.Replace(page.TitleContains)+"%")
+	}
+	if page.GenreID != 0 {
+		addCondition("id in (select movie_id from movies_genres where genre_id in ("+genreSubtree("$%d")+"))", page.GenreID)
+	}
+	if len(page.Tags) > 0 {
+		tags := lowerTags(page.Tags)
+		addCondition(taggedWithAll, tags, len(tags))
+	}
+	if page.MPAARating != "" {
+		addCondition("mpaa_rating = $%d", page.MPAARating)
+	}
+	if !page.ReleasedAfter.IsZero() {
+		addCondition("release_date >= $%d", page.ReleasedAfter)
+	}
+	if !page.ReleasedBefore.IsZero() {
+		addCondition("release_date < $%d", page.ReleasedBefore)
+	}
+
+	// Walking backwards reverses the order, and the rows are flipped back after scanning.
+	descending := page.Descending != page.Backward
+	after, before := ">", "<"
+	if page.Descending {
+		after, before = "<", ">"
+	}
+
+	keyset := "(%s, id) %s ($%%d::%s, $%%d)"
+	if page.After != nil {
+		addCondition(fmt.Sprintf(keyset, sortColumn[0], after, sortColumn[1]), page.After.Value, page.After.ID)
+	}
+	if page.Before != nil {
+		addCondition(fmt.Sprintf(keyset, sortColumn[0], before, sortColumn[1]), page.Before.Value, page.Before.ID)
+	}
+
+	direction := "asc"
+	if descending {
+		direction = "desc"
+	}
+
+	args = append(args, page.Limit)
+	query := fmt.Sprintf(`select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from movies where %s order by %s %s, id %s limit $%d`, strings.Join(conditions, " and "), sortColumn[0], direction, direction, len(args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies, err := scanMovies(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if page.Backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	return movies, nil
+}
+
+// taggedWithAll selects the movies that have every tag in a list of distinct lowercase names.
+const taggedWithAll = `id in (select mt.movie_id from movies_tags mt join tags t on (mt.tag_id = t.id)
+where lower(t.name) = any($%d) group by mt.movie_id having count(*) = $%d)`
+
+func lowerTags(names []string) []string {
+	lowered := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !slices.Contains(lowered, name) {
+			lowered = append(lowered, name)
+		}
+	}
+	return lowered
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func scanMovies(rows *sql.Rows) ([]*models.Movie, error) {
+	var movies []*models.Movie
+	for rows.Next() {
+		var movie models.Movie
+		err := rows.Scan(&movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version,
+			&movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+func (m *PostgresDBRepo) GetMovieByID(id int) (*models.Movie, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	var movie models.Movie
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '') from movies where id = $1 and deleted_at is null`
+	row := m.DB.QueryRowContext(ctx, query, id)
+
+	err := row.Scan(&movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version,
+		&movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `select g.id, g.genre, g.parent_id from movies_genres mg left join genres g on (mg.genre_id = g.id) where mg.movie_id = $1 order by g.genre`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	defer rows.Close()
+	var genres []*models.Genre
+	var genreArray []int
+
+	for rows.Next() {
+		var g models.Genre
+		err := rows.Scan(&g.ID, &g.Genre, &g.ParentID)
+		g.Checked = true
+		if err != nil {
+			return nil, err
+		}
+		genres = append(genres, &g)
+		genreArray = append(genreArray, g.ID)
+	}
+
+	movie.Genres = genres
+	movie.GenresArray = genreArray
+
+	tags, err := m.TagsForMovies([]int{id})
+	if err != nil {
+		return nil, err
+	}
+	movie.Tags = tags[id]
+
+	return &movie, nil
+}
+
+func (m *PostgresDBRepo) DeleteMovie(ctx context.Context, id int) error {
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := movieSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		query := `update movies set deleted_at = $1 where id = $2 and deleted_at is null`
+		result, err := tx.ExecContext(ctx, query, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		if err = expectRows(result); err != nil {
+			return err
+		}
+
+		err = touchEmbeddingMovies(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = enqueueMovieEvent(ctx, tx, events.MovieDeleted, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "delete", "movie", id, before, nil)
+	})
+}
+
+func (m *PostgresDBRepo) DeletedMovies() ([]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version, deleted_at,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from movies where deleted_at is not null order by deleted_at desc`
+	rows, err := m.DB.QueryContext(ctx, query)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var movies []*models.Movie
+	for rows.Next() {
+		var movie models.Movie
+		err := rows.Scan(&movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version, &movie.DeletedAt,
+			&movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+func (m *PostgresDBRepo) RestoreMovie(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := movieSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		query := `update movies set deleted_at = null, updated_at = $1, version = version + 1 where id = $2 and deleted_at is not null`
+		result, err := tx.ExecContext(ctx, query, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		if err = expectRows(result); err != nil {
+			return err
+		}
+
+		err = touchEmbeddingMovies(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueMovieEvent(ctx, tx, events.MovieRestored, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "restore", "movie", id, before, after)
+	})
+}
+
+func (m *PostgresDBRepo) PurgeMovie(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	ids, err := m.purgeMovies(ctx, `select id from movies where id = $1 and deleted_at is not null for update`, id)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) PurgeDeletedMovies(ctx context.Context, deletedBefore time.Time) ([]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.purgeMovies(ctx, `select id from movies where deleted_at < $1 for update`, deletedBefore)
+}
+
+// purgeMovies permanently removes the movies selected by query along with their genre links and
+// revisions. Tags, collection entries and relations are removed by cascading foreign keys.
+func (m *PostgresDBRepo) purgeMovies(ctx context.Context, query string, args ...interface{}) ([]int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		before, err := movieSnapshot(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from movies_genres where movie_id = $1`, id)
+		if err != nil {
+			return nil, err
+		}
+
+		err = touchEmbeddingMovies(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from movie_revisions where movie_id = $1`, id)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from movies where id = $1`, id)
+		if err != nil {
+			return nil, err
+		}
+
+		err = invalidation.Notify(ctx, tx, invalidation.Message{Entity: "movie", ID: id})
+		if err != nil {
+			return nil, err
+		}
+
+		err = recordAudit(ctx, tx, "purge", "movie", id, before, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func expectRows(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) CreateMovie(ctx context.Context, movie models.Movie) (int, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newId int
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		query := `insert into movies (title, description, release_date, runtime, mpaa_rating, image, created_at, updated_at, imdb_id, tmdb_id, wikidata_id) 
+values ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, ''), nullif($10, 0), nullif($11, '')) returning id`
+		result := tx.QueryRowContext(ctx, query, movie.Title, movie.Description, movie.ReleaseDate, movie.RunTime, movie.MPAARating, movie.Image, time.Now(), time.Now(),
+			movie.IMDbID, movie.TMDBID, movie.WikidataID)
+		err := result.Scan(&newId)
+		if err != nil {
+			return externalIDConflict(err)
+		}
+
+		err = setMovieGenres(ctx, tx, newId, movie.GenresArray)
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueMovieEvent(ctx, tx, events.MovieCreated, newId)
+		if err != nil {
+			return err
+		}
+
+		err = recordRevision(ctx, tx, after)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "create", "movie", newId, nil, after)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (m *PostgresDBRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	return m.updateMovie(ctx, movie, "update")
+}
+
+// RevertMovie replaces a movie's details with those of an earlier revision. It is recorded in
+// the audit log as a revert rather than an update.
+func (m *PostgresDBRepo) RevertMovie(ctx context.Context, movie models.Movie) error {
+	return m.updateMovie(ctx, movie, "revert")
+}
+
+func (m *PostgresDBRepo) updateMovie(ctx context.Context, movie models.Movie, action string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := movieSnapshot(ctx, tx, movie.ID)
+		if err != nil {
+			return err
+		}
+
+		query := `update movies set title=$1, description=$2, release_date=$3, runtime=$4, mpaa_rating=$5, image=$6, updated_at=$7, version = version + 1,
+imdb_id = nullif($10, ''), tmdb_id = nullif($11, 0), wikidata_id = nullif($12, '')
+where id = $8 and version = $9 and deleted_at is null`
+		result, err := tx.ExecContext(ctx, query, movie.Title, movie.Description, movie.ReleaseDate, movie.RunTime, movie.MPAARating, movie.Image, time.Now(), movie.ID, movie.Version,
+			movie.IMDbID, movie.TMDBID, movie.WikidataID)
+		if err != nil {
+			return externalIDConflict(err)
+		}
+
+		if err = checkVersion(ctx, tx, result, movie.ID); err != nil {
+			return err
+		}
+
+		if movie.GenresArray != nil {
+			err = setMovieGenres(ctx, tx, movie.ID, movie.GenresArray)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = touchEmbeddingMovies(ctx, tx, movie.ID)
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueMovieEvent(ctx, tx, events.MovieUpdated, movie.ID)
+		if err != nil {
+			return err
+		}
+
+		err = recordRevision(ctx, tx, after)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, action, "movie", movie.ID, before, after)
+	})
+}
+
+var patchableMovieColumns = map[string]bool{
+	"title":        true,
+	"description":  true,
+	"release_date": true,
+	"runtime":      true,
+	"mpaa_rating":  true,
+	"image":        true,
+	"imdb_id":      true,
+	"tmdb_id":      true,
+	"wikidata_id":  true,
+}
+
+// externalIDZeros holds the value of each external ID column that is stored as null.
+var externalIDZeros = map[string]string{
+	"imdb_id":     "''",
+	"tmdb_id":     "0",
+	"wikidata_id": "''",
+}
+
+func (m *PostgresDBRepo) PatchMovie(ctx context.Context, id, version int, changes map[string]interface{}, genreIDs []int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	columns := make([]string, 0, len(changes))
+	for column := range changes {
+		if !patchableMovieColumns[column] {
+			return fmt.Errorf("column %q cannot be patched", column)
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	var assignments []string
+	var args []interface{}
+	for _, column := range columns {
+		args = append(args, changes[column])
+		if zero, ok := externalIDZeros[column]; ok {
+			assignments = append(assignments, fmt.Sprintf("%s = nullif($%d, %s)", column, len(args), zero))
+			continue
+		}
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+
+	args = append(args, time.Now())
+	assignments = append(assignments, fmt.Sprintf("updated_at = $%d", len(args)))
+	assignments = append(assignments, "version = version + 1")
+
+	args = append(args, id, version)
+	query := fmt.Sprintf(`update movies set %s where id = $%d and version = $%d and deleted_at is null`,
+		strings.Join(assignments, ", "), len(args)-1, len(args))
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := movieSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return externalIDConflict(err)
+		}
+
+		if err = checkVersion(ctx, tx, result, id); err != nil {
+			return err
+		}
+
+		if genreIDs != nil {
+			err = setMovieGenres(ctx, tx, id, genreIDs)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = touchEmbeddingMovies(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueMovieEvent(ctx, tx, events.MovieUpdated, id)
+		if err != nil {
+			return err
+		}
+
+		err = recordRevision(ctx, tx, after)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "update", "movie", id, before, after)
+	})
+}
+
+// externalIDConflict translates a unique violation (SQLSTATE 23505) on one of the external ID
+// columns into ErrDuplicateExternalID.
+func externalIDConflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "movies_imdb_id_idx", "movies_tmdb_id_idx", "movies_wikidata_id_idx":
+			return repository.ErrDuplicateExternalID
+		}
+	}
+	return err
+}
+
+// FindDuplicateMovie looks for an existing movie, including trashed ones, that shares an
+// external ID with movie or, failing that, has the same normalized title and release year.
+func (m *PostgresDBRepo) FindDuplicateMovie(movie models.Movie) (*models.Duplicate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	columns := `id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version, deleted_at,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')`
+
+	find := func(query string, args ...interface{}) (*models.Duplicate, error) {
+		var duplicate models.Duplicate
+		var found models.Movie
+		var deletedAt sql.NullTime
+		err := m.DB.QueryRowContext(ctx, query, args...).Scan(&duplicate.Reason, &found.ID, &found.Title, &found.ReleaseDate, &found.RunTime,
+			&found.MPAARating, &found.Description, &found.Image, &found.CreatedAt, &found.UpdatedAt, &found.Version, &deletedAt,
+			&found.IMDbID, &found.TMDBID, &found.WikidataID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if deletedAt.Valid {
+			found.DeletedAt = &deletedAt.Time
+		}
+		duplicate.Movie = &found
+		return &duplicate, nil
+	}
+
+	if movie.IMDbID != "" || movie.TMDBID != 0 || movie.WikidataID != "" {
+		query := `select case when imdb_id = nullif($1, '') then 'imdb_id' when tmdb_id = nullif($2, 0) then 'tmdb_id' else 'wikidata_id' end, ` + columns + `
+from movies where imdb_id = nullif($1, '') or tmdb_id = nullif($2, 0) or wikidata_id = nullif($3, '')
+order by id limit 1`
+		duplicate, err := find(query, movie.IMDbID, movie.TMDBID, movie.WikidataID)
+		if duplicate != nil || err != nil {
+			return duplicate, err
+		}
+	}
+
+	if movie.ReleaseDate.IsZero() {
+		return nil, nil
+	}
+
+	query := `select $1::text, ` + columns + `
+from movies
+where regexp_replace(lower(title), '[^[:alnum:]]+', '', 'g') = regexp_replace(lower($2), '[^[:alnum:]]+', '', 'g')
+and release_date >= $3 and release_date < $4
+order by deleted_at nulls first, id limit 1`
+	year := time.Date(movie.ReleaseDate.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	return find(query, models.DuplicateTitle, movie.Title, year, year.AddDate(1, 0, 0))
+}
+
+// checkVersion reports ErrVersionMismatch when a versioned update touched no rows
+// but the movie still exists, and sql.ErrNoRows when it does not.
+func checkVersion(ctx context.Context, tx *sql.Tx, result sql.Result, id int) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected > 0 {
+		return nil
+	}
+
+	var exists bool
+	query := `select exists(select 1 from movies where id = $1 and deleted_at is null)`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return repository.ErrVersionMismatch
+	}
+
+	return sql.ErrNoRows
+}
+
+func (m *PostgresDBRepo) Connection() *sql.DB {
+	return m.DB
+}
+
+func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+	query := `select id, first_name, last_name, email, password, created_at, updated_at from users where email = $1`
+	var user models.User
+	row := m.DB.QueryRowContext(ctx, query, email)
+	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, created_at, updated_at from users where id = $1`
+	var user models.User
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (m *PostgresDBRepo) CreateMovieGenre(ctx context.Context, id int, genreIDs []int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := movieSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		err = setMovieGenres(ctx, tx, id, genreIDs)
+		if err != nil {
+			return err
+		}
+
+		// the movie's representation changed, so it counts as modified
+		_, err = tx.ExecContext(ctx, `update movies set updated_at = $1, version = version + 1 where id = $2`, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueMovieEvent(ctx, tx, events.MovieUpdated, id)
+		if err != nil {
+			return err
+		}
+
+		err = recordRevision(ctx, tx, after)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "update", "movie", id, before, after)
+	})
+}
+
+func setMovieGenres(ctx context.Context, tx *sql.Tx, id int, genreIDs []int) error {
+	query := `delete from movies_genres where movie_id = $1`
+	_, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range genreIDs {
+		query := `insert into movies_genres (movie_id, genre_id) values ($1, $2)`
+		_, err := tx.ExecContext(ctx, query, id, n)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Tags returns the tags of movies outside the trash whose names start with prefix, ignoring
+// case, most used first.
+func (m *PostgresDBRepo) Tags(prefix string, limit int) ([]*models.Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select t.id, t.name, t.created_at, t.updated_at, count(*)
+from tags t
+join movies_tags mt on (mt.tag_id = t.id)
+join movies m on (m.id = mt.movie_id and m.deleted_at is null)
+where t.name ilike $1
+group by t.id
+order by count(*) desc, lower(t.name)
+limit $2`
+	rows, err := m.DB.QueryContext(ctx, query, likeEscaper.Replace(prefix)+"%", limit)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []*models.Tag
+	for rows.Next() {
+		var tag models.Tag
+		err := rows.Scan(&tag.ID, &tag.Name, &tag.CreatedAt, &tag.UpdatedAt, &tag.MovieCount)
+		if err != nil {
+			return nil, err
+		}
+
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (m *PostgresDBRepo) TagsForMovies(movieIDs []int) (map[int][]*models.Tag, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select mt.movie_id, t.id, t.name from movies_tags mt join tags t on (mt.tag_id = t.id)
+where mt.movie_id = any($1) order by lower(t.name)`
+	rows, err := m.DB.QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[int][]*models.Tag)
+	for rows.Next() {
+		var movieID int
+		var tag models.Tag
+		err := rows.Scan(&movieID, &tag.ID, &tag.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		tags[movieID] = append(tags[movieID], &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// MoviesByTags returns the movies outside the trash that have every tag in names, optionally
+// limited to a genre and its subgenres.
+func (m *PostgresDBRepo) MoviesByTags(names []string, genreID int) ([]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tags := lowerTags(names)
+	where := "deleted_at is null and " + fmt.Sprintf(taggedWithAll, 1, 2)
+	args := []interface{}{tags, len(tags)}
+	if genreID != 0 {
+		where += " and id in (select movie_id from movies_genres where genre_id in (" + genreSubtree("$3") + "))"
+		args = append(args, genreID)
+	}
+
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from movies where ` + where + ` order by title`
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMovies(rows)
+}
+
+// SetMovieTags replaces the tags of a movie, creating tags that do not exist yet. Names are
+// matched ignoring case, so an existing tag keeps its original spelling.
+func (m *PostgresDBRepo) SetMovieTags(ctx context.Context, id int, names []string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := movieSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, `update movies set updated_at = $1, version = version + 1 where id = $2 and deleted_at is null`, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		if err = expectRows(result); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			query := `insert into tags (name, created_at, updated_at) values ($1, $2, $3) on conflict ((lower(name))) do nothing`
+			_, err = tx.ExecContext(ctx, query, name, time.Now(), time.Now())
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from movies_tags where movie_id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		query := `insert into movies_tags (movie_id, tag_id) select $1, id from tags where lower(name) = any($2)`
+		_, err = tx.ExecContext(ctx, query, id, lowerTags(names))
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueMovieEvent(ctx, tx, events.MovieUpdated, id)
+		if err != nil {
+			return err
+		}
+
+		err = recordRevision(ctx, tx, after)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "update", "movie", id, before, after)
+	})
+}
+
+func (m *PostgresDBRepo) Collections() ([]*models.Collection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, name, description, image, created_at, updated_at from collections order by name, id`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []*models.Collection
+	for rows.Next() {
+		var collection models.Collection
+		err := rows.Scan(&collection.ID, &collection.Name, &collection.Description, &collection.Image, &collection.CreatedAt, &collection.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// GetCollection returns a collection with its movies outside the trash, in order.
+func (m *PostgresDBRepo) GetCollection(id int) (*models.Collection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var collection models.Collection
+	query := `select id, name, description, image, created_at, updated_at from collections where id = $1`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&collection.ID, &collection.Name, &collection.Description, &collection.Image,
+		&collection.CreatedAt, &collection.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	movies, err := m.MoviesForCollections([]int{id})
+	if err != nil {
+		return nil, err
+	}
+
+	collection.Movies = movies[id]
+	if collection.Movies == nil {
+		collection.Movies = []*models.Movie{}
+	}
+	for _, movie := range collection.Movies {
+		collection.MovieIDs = append(collection.MovieIDs, movie.ID)
+	}
+
+	return &collection, nil
+}
+
+func (m *PostgresDBRepo) MoviesForCollections(collectionIDs []int) (map[int][]*models.Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select cm.collection_id, mv.id, mv.title, mv.release_date, mv.runtime, mv.mpaa_rating, mv.description, coalesce(mv.image, ''),
+mv.created_at, mv.updated_at, mv.version, coalesce(mv.imdb_id, ''), coalesce(mv.tmdb_id, 0), coalesce(mv.wikidata_id, '')
+from collection_movies cm join movies mv on (cm.movie_id = mv.id)
+where cm.collection_id = any($1) and mv.deleted_at is null
+order by cm.collection_id, cm.position`
+	rows, err := m.DB.QueryContext(ctx, query, collectionIDs)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := make(map[int][]*models.Movie)
+	for rows.Next() {
+		var collectionID int
+		var movie models.Movie
+		err := rows.Scan(&collectionID, &movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description,
+			&movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version, &movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+		if err != nil {
+			return nil, err
+		}
+
+		movies[collectionID] = append(movies[collectionID], &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// InsertCollection creates a collection holding collection.MovieIDs in order.
+func (m *PostgresDBRepo) InsertCollection(ctx context.Context, collection models.Collection) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		query := `insert into collections (name, description, image, created_at, updated_at) values ($1, $2, $3, $4, $5) returning id`
+		err := tx.QueryRowContext(ctx, query, collection.Name, collection.Description, collection.Image, time.Now(), time.Now()).Scan(&newID)
+		if err != nil {
+			return err
+		}
+
+		err = setCollectionMovies(ctx, tx, newID, collection.MovieIDs)
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueCollectionEvent(ctx, tx, events.CollectionCreated, newID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "create", "collection", newID, nil, after)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateCollection replaces a collection's details, and its movies when collection.MovieIDs
+// is not nil.
+func (m *PostgresDBRepo) UpdateCollection(ctx context.Context, collection models.Collection) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := collectionSnapshot(ctx, tx, collection.ID)
+		if err != nil {
+			return err
+		}
+
+		query := `update collections set name = $1, description = $2, image = $3, updated_at = $4 where id = $5`
+		_, err = tx.ExecContext(ctx, query, collection.Name, collection.Description, collection.Image, time.Now(), collection.ID)
+		if err != nil {
+			return err
+		}
+
+		if collection.MovieIDs != nil {
+			err = setCollectionMovies(ctx, tx, collection.ID, collection.MovieIDs)
+		} else {
+			err = touchCollectionMovies(ctx, tx, collection.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err := enqueueCollectionEvent(ctx, tx, events.CollectionUpdated, collection.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "update", "collection", collection.ID, before, after)
+	})
+}
+
+func (m *PostgresDBRepo) DeleteCollection(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		err := touchCollectionMovies(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		before, err := enqueueCollectionEvent(ctx, tx, events.CollectionDeleted, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from collections where id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "delete", "collection", id, before, nil)
+	})
+}
+
+// touchCollectionMovies bumps updated_at and the version of the collection's movies, whose
+// detail responses embed the collection.
+func touchCollectionMovies(ctx context.Context, tx *sql.Tx, id int) error {
+	query := `update movies set updated_at = $1, version = version + 1 where id in (select movie_id from collection_movies where collection_id = $2)`
+	_, err := tx.ExecContext(ctx, query, time.Now(), id)
+	return err
+}
+
+// touchEmbeddingMovies bumps updated_at and the version of the movies whose detail responses
+// embed the given movie: its related movies and its neighbours in its collections.
+func touchEmbeddingMovies(ctx context.Context, tx *sql.Tx, id int) error {
+	query := `update movies set updated_at = $1, version = version + 1 where id <> $2 and id in (
+	select related_movie_id from movie_relations where movie_id = $2
+	union select movie_id from movie_relations where related_movie_id = $2
+	union select other.movie_id from collection_movies mine
+	join collection_movies other on (other.collection_id = mine.collection_id and abs(other.position - mine.position) = 1)
+	where mine.movie_id = $2
+)`
+	_, err := tx.ExecContext(ctx, query, time.Now(), id)
+	return err
+}
+
+func setCollectionMovies(ctx context.Context, tx *sql.Tx, id int, movieIDs []int) error {
+	seen := make(map[int]bool, len(movieIDs))
+	for _, movieID := range movieIDs {
+		if seen[movieID] {
+			return errors.New("a movie can only appear once in a collection")
+		}
+		seen[movieID] = true
+	}
+
+	var known int
+	err := tx.QueryRowContext(ctx, `select count(*) from movies where id = any($1)`, movieIDs).Scan(&known)
+	if err != nil {
+		return err
+	}
+	if known != len(movieIDs) {
+		return errors.New("collection lists a movie that does not exist")
+	}
+
+	// movies leaving the collection change as well as those joining it
+	err = touchCollectionMovies(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from collection_movies where collection_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	for i, movieID := range movieIDs {
+		query := `insert into collection_movies (collection_id, movie_id, position) values ($1, $2, $3)`
+		_, err = tx.ExecContext(ctx, query, id, movieID, i+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return touchCollectionMovies(ctx, tx, id)
+}
+
+// collectionSnapshot loads a collection with the IDs of its movies as it stands inside tx, and
+// locks its row until tx ends.
+func collectionSnapshot(ctx context.Context, tx *sql.Tx, id int) (*models.Collection, error) {
+	var collection models.Collection
+	query := `select id, name, description, image, created_at, updated_at from collections where id = $1 for update`
+	err := tx.QueryRowContext(ctx, query, id).Scan(&collection.ID, &collection.Name, &collection.Description, &collection.Image,
+		&collection.CreatedAt, &collection.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `select movie_id from collection_movies where collection_id = $1 order by position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movieID int
+		if err := rows.Scan(&movieID); err != nil {
+			return nil, err
+		}
+		collection.MovieIDs = append(collection.MovieIDs, movieID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &collection, nil
+}
+
+// enqueueCollectionEvent writes an outbox event carrying a snapshot of the collection as it
+// stands inside tx, and returns the snapshot.
+func enqueueCollectionEvent(ctx context.Context, tx *sql.Tx, eventType string, id int) (*models.Collection, error) {
+	collection, err := collectionSnapshot(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, enqueueEvent(ctx, tx, eventType, "collection", id, collection)
+}
+
+// CollectionEntriesForMovies returns the collections of each movie, ordered by name, with its
+// neighbours outside the trash in each.
+func (m *PostgresDBRepo) CollectionEntriesForMovies(movieIDs []int) (map[int][]*models.CollectionEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select r.movie_id, c.id, c.name, r.position, coalesce(r.previous_id, 0), coalesce(r.next_id, 0)
+from (
+	select cm.collection_id, cm.movie_id, cm.position,
+		lag(cm.movie_id) over ordered as previous_id, lead(cm.movie_id) over ordered as next_id
+	from collection_movies cm join movies mv on (mv.id = cm.movie_id and mv.deleted_at is null)
+	where cm.collection_id in (select collection_id from collection_movies where movie_id = any($1))
+	window ordered as (partition by cm.collection_id order by cm.position)
+) r
+join collections c on (c.id = r.collection_id)
+where r.movie_id = any($1)
+order by c.name, c.id`
+	rows, err := m.DB.QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make(map[int][]*models.CollectionEntry)
+	neighbours := make(map[*models.CollectionEntry][2]int)
+	var neighbourIDs []int
+	for rows.Next() {
+		var movieID, previousID, nextID int
+		var entry models.CollectionEntry
+		err := rows.Scan(&movieID, &entry.ID, &entry.Name, &entry.Position, &previousID, &nextID)
+		if err != nil {
+			return nil, err
+		}
+
+		entries[movieID] = append(entries[movieID], &entry)
+		neighbours[&entry] = [2]int{previousID, nextID}
+		neighbourIDs = append(neighbourIDs, previousID, nextID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(neighbourIDs) == 0 {
+		return entries, nil
+	}
+
+	query = `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from movies where id = any($1)`
+	neighbourRows, err := m.DB.QueryContext(ctx, query, neighbourIDs)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer neighbourRows.Close()
+
+	movies, err := scanMovies(neighbourRows)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int]*models.Movie, len(movies))
+	for _, movie := range movies {
+		byID[movie.ID] = movie
+	}
+	for entry, ids := range neighbours {
+		entry.Previous = byID[ids[0]]
+		entry.Next = byID[ids[1]]
+	}
+
+	return entries, nil
+}
+
+// RelationsForMovies returns the relations of each movie to other movies outside the trash,
+// in both directions.
+func (m *PostgresDBRepo) RelationsForMovies(movieIDs []int) (map[int][]*models.MovieRelation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select rel.subject, rel.relation, rel.inverse,
+	mv.id, mv.title, mv.release_date, mv.runtime, mv.mpaa_rating, mv.description, coalesce(mv.image, ''), mv.created_at, mv.updated_at, mv.version,
+	coalesce(mv.imdb_id, ''), coalesce(mv.tmdb_id, 0), coalesce(mv.wikidata_id, '')
+from (
+	select movie_id as subject, related_movie_id as other, relation, false as inverse from movie_relations where movie_id = any($1)
+	union all
+	select related_movie_id, movie_id, relation, true from movie_relations where related_movie_id = any($1)
+) rel
+join movies mv on (mv.id = rel.other and mv.deleted_at is null)
+order by rel.subject, rel.relation, mv.release_date, mv.id`
+	rows, err := m.DB.QueryContext(ctx, query, movieIDs)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	relations := make(map[int][]*models.MovieRelation)
+	for rows.Next() {
+		var subject int
+		var movie models.Movie
+		relation := models.MovieRelation{Movie: &movie}
+		err := rows.Scan(&subject, &relation.Type, &relation.Inverse, &movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime,
+			&movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version, &movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+		if err != nil {
+			return nil, err
+		}
+
+		relations[subject] = append(relations[subject], &relation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return relations, nil
+}
+
+// AddMovieRelation records that movieID relates to relatedID, for example as its sequel.
+// Adding a relation that exists already does nothing.
+// AddMovieRelation relates movieID to relatedID. Adding a relation that already exists changes
+// nothing, so neither movie's version moves and no events are sent.
+func (m *PostgresDBRepo) AddMovieRelation(ctx context.Context, movieID, relatedID int, relation string) error {
+	if movieID == relatedID {
+		return repository.ErrSelfRelation
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `select id from movies where id in ($1, $2) and deleted_at is null for update`, movieID, relatedID)
+		if err != nil {
+			return err
+		}
+		found := 0
+		for rows.Next() {
+			found++
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if found != 2 {
+			return sql.ErrNoRows
+		}
+
+		query := `insert into movie_relations (movie_id, related_movie_id, relation, created_at) values ($1, $2, $3, $4) on conflict do nothing`
+		result, err := tx.ExecContext(ctx, query, movieID, relatedID, relation, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `update movies set updated_at = $1, version = version + 1 where id in ($2, $3)`, time.Now(), movieID, relatedID)
+		if err != nil {
+			return err
+		}
+
+		err = enqueueRelationEvents(ctx, tx, movieID, relatedID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "create", "movie_relation", movieID, nil, relationSnapshot(relatedID, relation))
+	})
+}
+
+func (m *PostgresDBRepo) RemoveMovieRelation(ctx context.Context, movieID, relatedID int, relation string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		query := `delete from movie_relations where movie_id = $1 and related_movie_id = $2 and relation = $3`
+		result, err := tx.ExecContext(ctx, query, movieID, relatedID, relation)
+		if err != nil {
+			return err
+		}
+
+		if err = expectRows(result); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `update movies set updated_at = $1, version = version + 1 where id in ($2, $3)`, time.Now(), movieID, relatedID)
+		if err != nil {
+			return err
+		}
+
+		err = enqueueRelationEvents(ctx, tx, movieID, relatedID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "delete", "movie_relation", movieID, relationSnapshot(relatedID, relation), nil)
+	})
+}
+
+// relationSnapshot is the audit log representation of a relation, recorded against the
+// movie it is stored from.
+func relationSnapshot(relatedID int, relation string) map[string]interface{} {
+	return map[string]interface{}{"type": relation, "related_movie_id": relatedID}
+}
+
+// enqueueRelationEvents announces that both movies of a relation changed.
+func enqueueRelationEvents(ctx context.Context, tx *sql.Tx, movieIDs ...int) error {
+	for _, id := range movieIDs {
+		_, err := enqueueMovieEvent(ctx, tx, events.MovieUpdated, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *PostgresDBRepo) MovieRevisions(movieID int) ([]*models.MovieRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, movie_id, revision, coalesce(actor_id, 0), created_at from movie_revisions where movie_id = $1 order by revision desc`
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*models.MovieRevision
+	for rows.Next() {
+		var revision models.MovieRevision
+		err := rows.Scan(&revision.ID, &revision.MovieID, &revision.Revision, &revision.ActorID, &revision.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+func (m *PostgresDBRepo) GetMovieRevision(movieID, revision int) (*models.MovieRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, movie_id, revision, snapshot, coalesce(actor_id, 0), created_at from movie_revisions where movie_id = $1 and revision = $2`
+	var result models.MovieRevision
+	var snapshot []byte
+	err := m.DB.QueryRowContext(ctx, query, movieID, revision).Scan(&result.ID, &result.MovieID, &result.Revision, &snapshot, &result.ActorID, &result.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(snapshot, &result.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (m *PostgresDBRepo) AuditEntries(filter models.AuditFilter) ([]*models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.EntityType != "" {
+		addCondition("entity_type = $%d", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		addCondition("entity_id = $%d", filter.EntityID)
+	}
+	if filter.ActorID != 0 {
+		addCondition("actor_id = $%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("created_at < $%d", filter.Until)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "where " + strings.Join(conditions, " and ")
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`select id, coalesce(actor_id, 0), action, entity_type, entity_id, before, after, diff, request_id, created_at
+from audit_log %s order by id desc limit $%d offset $%d`, where, len(args)-1, len(args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var before, after, diff []byte
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.EntityType, &entry.EntityID, &before, &after, &diff, &entry.RequestID, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.Before = before
+		entry.After = after
+		if len(diff) > 0 {
+			err = json.Unmarshal(diff, &entry.Diff)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (m *PostgresDBRepo) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// movieSnapshot loads a movie, including its genres and tags, as it stands inside tx, and locks
+// its row until tx ends. Trashed movies are included.
+func movieSnapshot(ctx context.Context, tx *sql.Tx, id int) (*models.Movie, error) {
+	var movie models.Movie
+	var deletedAt sql.NullTime
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at, version, deleted_at,
+coalesce(imdb_id, ''), coalesce(tmdb_id, 0), coalesce(wikidata_id, '')
+from movies where id = $1 for update`
+	err := tx.QueryRowContext(ctx, query, id).Scan(&movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating,
+		&movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version, &deletedAt,
+		&movie.IMDbID, &movie.TMDBID, &movie.WikidataID)
+	if err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		movie.DeletedAt = &deletedAt.Time
+	}
+
+	query = `select g.id, g.genre from movies_genres mg join genres g on (mg.genre_id = g.id) where mg.movie_id = $1 order by g.genre`
+	rows, err := tx.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Genre
+		err := rows.Scan(&g.ID, &g.Genre)
+		if err != nil {
+			return nil, err
+		}
+		g.Checked = true
+		movie.Genres = append(movie.Genres, &g)
+		movie.GenresArray = append(movie.GenresArray, g.ID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `select t.id, t.name from movies_tags mt join tags t on (mt.tag_id = t.id) where mt.movie_id = $1 order by lower(t.name)`
+	tagRows, err := tx.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer tagRows.Close()
+
+	for tagRows.Next() {
+		var tag models.Tag
+		err := tagRows.Scan(&tag.ID, &tag.Name)
+		if err != nil {
+			return nil, err
+		}
+		movie.Tags = append(movie.Tags, &tag)
+	}
+
+	if err = tagRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+// enqueueMovieEvent writes an outbox event carrying a snapshot of the movie as it stands inside
+// tx, and returns the snapshot.
+func enqueueMovieEvent(ctx context.Context, tx *sql.Tx, eventType string, id int) (*models.Movie, error) {
+	movie, err := movieSnapshot(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return movie, enqueueEvent(ctx, tx, eventType, "movie", id, movie)
+}
+
+// recordRevision stores the next revision of a movie's content. Callers hold the movie's row
+// lock, taken by movieSnapshot, so concurrent changes to one movie cannot number two revisions
+// the same.
+func recordRevision(ctx context.Context, tx *sql.Tx, movie *models.Movie) error {
+	data, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	var actorID sql.NullInt64
+	if actor := repository.ActorFromContext(ctx); actor.ID != 0 {
+		actorID = sql.NullInt64{Int64: int64(actor.ID), Valid: true}
+	}
 
-	var movies []*models.Movie
-	where := ""
-	if len(genres) > 0 {
-		where = fmt.Sprintf("where id in (select movie_id from movies_genres where genre_id = %d)", genres[0])
+	query := `insert into movie_revisions (movie_id, revision, snapshot, actor_id, created_at)
+select $1, coalesce(max(revision), 0) + 1, $2, $3, $4 from movie_revisions where movie_id = $1`
+	_, err = tx.ExecContext(ctx, query, movie.ID, data, actorID, time.Now())
+	return err
+}
+
+// recordAudit writes an audit log entry for a change in the same transaction as the change,
+// attributed to the actor attached to ctx. A nil before or after marks a creation or deletion.
+func recordAudit(ctx context.Context, tx *sql.Tx, action, entityType string, entityID int, before, after interface{}) error {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
 	}
-	query := fmt.Sprintf(`select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at from movies %s order by title`, where)
-	rows, err := m.DB.QueryContext(ctx, query)
 
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+
+	diff, err := json.Marshal(models.DiffJSON(beforeJSON, afterJSON))
+	if err != nil {
+		return err
+	}
+
+	actor := repository.ActorFromContext(ctx)
+	var actorID sql.NullInt64
+	if actor.ID != 0 {
+		actorID = sql.NullInt64{Int64: int64(actor.ID), Valid: true}
+	}
+
+	query := `insert into audit_log (actor_id, action, entity_type, entity_id, before, after, diff, request_id, created_at)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.ExecContext(ctx, query, actorID, action, entityType, entityID,
+		nullJSON(beforeJSON), nullJSON(afterJSON), diff, actor.RequestID, time.Now())
+	return err
+}
+
+// auditJSON marshals an audit snapshot, treating a nil snapshot, including a typed nil
+// pointer, as no snapshot at all.
+func auditJSON(snapshot interface{}) (json.RawMessage, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil || string(data) == "null" {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// enqueueEvent writes an event to the outbox in the same transaction as the change it
+// describes, so the event is published if and only if the change is committed. It also
+// notifies other replicas so they drop cached copies of the entity once the change commits.
+func enqueueEvent(ctx context.Context, tx *sql.Tx, eventType, entityType string, entityID int, snapshot interface{}) error {
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	query := `insert into outbox (entity_type, entity_id, event_type, payload, created_at) values ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, query, entityType, entityID, eventType, payload, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return invalidation.Notify(ctx, tx, invalidation.Message{Entity: entityType, ID: entityID})
+}
+
+// ClaimOutboxEvents leases up to limit pending events until leaseUntil so that relays on other
+// replicas skip them, and returns them in the order they were written. An event is not claimed
+// while an earlier event for the same entity is leased, which keeps each entity's events in order.
+func (m *PostgresDBRepo) ClaimOutboxEvents(limit int, leaseUntil time.Time) ([]*models.OutboxEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `update outbox set locked_until = $1
+where id in (
+	select o.id from outbox o
+	where o.published_at is null and o.dead_at is null and (o.locked_until is null or o.locked_until < $2)
+	and not exists (
+		select 1 from outbox earlier
+		where earlier.entity_type = o.entity_type and earlier.entity_id = o.entity_id and earlier.id < o.id
+		and earlier.published_at is null and earlier.dead_at is null and earlier.locked_until >= $2
+	)
+	order by o.id limit $3
+	for update skip locked
+)
+returning id, entity_type, entity_id, event_type, payload, attempts, last_error, delivered_sinks, created_at`
+	rows, err := m.DB.QueryContext(ctx, query, leaseUntil, time.Now(), limit)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
 
+	var outbox []*models.OutboxEvent
 	for rows.Next() {
-		var movie models.Movie
-		err := rows.Scan(&movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt)
+		var event models.OutboxEvent
+		var payload, deliveredSinks []byte
+		err := rows.Scan(&event.ID, &event.EntityType, &event.EntityID, &event.EventType, &payload, &event.Attempts, &event.LastError,
+			&deliveredSinks, &event.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
 
-		movies = append(movies, &movie)
+		err = json.Unmarshal(deliveredSinks, &event.DeliveredSinks)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Payload = payload
+		outbox = append(outbox, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
+	// update ... returning does not keep the order of the subquery
+	sort.Slice(outbox, func(i, j int) bool { return outbox[i].ID < outbox[j].ID })
+
+	return outbox, nil
+}
+
+func (m *PostgresDBRepo) GetOutboxEvent(id int64) (*models.OutboxEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, entity_type, entity_id, event_type, payload, attempts, last_error, delivered_sinks, created_at, published_at, dead_at
+from outbox where id = $1`
+	var event models.OutboxEvent
+	var payload, deliveredSinks []byte
+	var publishedAt, deadAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&event.ID, &event.EntityType, &event.EntityID, &event.EventType, &payload,
+		&event.Attempts, &event.LastError, &deliveredSinks, &event.CreatedAt, &publishedAt, &deadAt)
 	if err != nil {
-		return movies, err
+		return nil, err
 	}
 
-	return movies, nil
+	err = json.Unmarshal(deliveredSinks, &event.DeliveredSinks)
+	if err != nil {
+		return nil, err
+	}
+
+	event.Payload = payload
+	if publishedAt.Valid {
+		event.PublishedAt = &publishedAt.Time
+	}
+	if deadAt.Valid {
+		event.DeadAt = &deadAt.Time
+	}
+
+	return &event, nil
 }
 
-func (m *PostgresDBRepo) GetMovieByID(id int) (*models.Movie, error) {
+func (m *PostgresDBRepo) MarkOutboxEventPublished(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
+	query := `update outbox set published_at = $1, attempts = attempts + 1, last_error = '', locked_until = null where id = $2`
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// RecordOutboxEventFailure stores the attempts, error, delivered sinks and dead-letter time of
+// an event that failed, and keeps it leased until retryAt.
+func (m *PostgresDBRepo) RecordOutboxEventFailure(event models.OutboxEvent, retryAt time.Time) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	var movie models.Movie
-	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at from movies where id = $1`
-	row := m.DB.QueryRowContext(ctx, query, id)
 
-	err := row.Scan(&movie.ID, &movie.Title, &movie.ReleaseDate, &movie.RunTime, &movie.MPAARating, &movie.Description, &movie.Image, &movie.CreatedAt, &movie.UpdatedAt)
+	if event.DeliveredSinks == nil {
+		event.DeliveredSinks = []string{}
+	}
+
+	deliveredSinks, err := json.Marshal(event.DeliveredSinks)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	query = `select g.id, g.genre from movies_genres mg left join genres g on (mg.genre_id = g.id) where mg.movie_id = $1 order by g.genre`
-	rows, err := m.DB.QueryContext(ctx, query, id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+	query := `update outbox set attempts = $1, last_error = $2, delivered_sinks = $3, dead_at = $4, locked_until = $5 where id = $6`
+	result, err := m.DB.ExecContext(ctx, query, event.Attempts, event.LastError, deliveredSinks, event.DeadAt, retryAt, event.ID)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+func (m *PostgresDBRepo) DeletePublishedOutboxEvents(publishedBefore time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from outbox where published_at < $1`, publishedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func (m *PostgresDBRepo) AllWebhooks() ([]*models.Webhook, error) {
+	return m.webhooks(`select id, url, event_types, secret, active, created_at, updated_at from webhooks order by id`)
+}
+
+func (m *PostgresDBRepo) ActiveWebhooks() ([]*models.Webhook, error) {
+	return m.webhooks(`select id, url, event_types, secret, active, created_at, updated_at from webhooks where active order by id`)
+}
+
+func (m *PostgresDBRepo) webhooks(query string, args ...interface{}) ([]*models.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Println(err)
 		return nil, err
 	}
 	defer rows.Close()
-	var genres []*models.Genre
-	var genreArray []int
 
+	var webhooks []*models.Webhook
 	for rows.Next() {
-		var g models.Genre
-		err := rows.Scan(&g.ID, &g.Genre)
-		g.Checked = true
+		webhook, err := scanWebhook(rows)
 		if err != nil {
 			return nil, err
 		}
-		genres = append(genres, &g)
-		genreArray = append(genreArray, g.ID)
+
+		webhooks = append(webhooks, webhook)
 	}
 
-	movie.Genres = genres
-	movie.GenresArray = genreArray
-	return &movie, nil
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
 }
 
-func (m *PostgresDBRepo) DeleteMovie(id int) error {
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*models.Webhook, error) {
+	var webhook models.Webhook
+	var eventTypes []byte
+	err := row.Scan(&webhook.ID, &webhook.URL, &eventTypes, &webhook.Secret, &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(eventTypes, &webhook.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
 
+func (m *PostgresDBRepo) GetWebhook(id int) (*models.Webhook, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `delete from movies where id = $1`
-	_, err := m.DB.ExecContext(ctx, query, id)
 
+	query := `select id, url, event_types, secret, active, created_at, updated_at from webhooks where id = $1`
+	return scanWebhook(m.DB.QueryRowContext(ctx, query, id))
+}
+
+func (m *PostgresDBRepo) InsertWebhook(ctx context.Context, webhook models.Webhook) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	eventTypes, err := json.Marshal(webhook.EventTypes)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = m.inTx(ctx, func(tx *sql.Tx) error {
+		query := `insert into webhooks (url, event_types, secret, active, created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6) returning id`
+		err := tx.QueryRowContext(ctx, query, webhook.URL, eventTypes, webhook.Secret, webhook.Active, time.Now(), time.Now()).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		after, err := webhookSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "create", "webhook", id, nil, after)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *PostgresDBRepo) UpdateWebhook(ctx context.Context, webhook models.Webhook) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	eventTypes, err := json.Marshal(webhook.EventTypes)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := webhookSnapshot(ctx, tx, webhook.ID)
+		if err != nil {
+			return err
+		}
+
+		query := `update webhooks set url = $1, event_types = $2, secret = $3, active = $4, updated_at = $5 where id = $6`
+		_, err = tx.ExecContext(ctx, query, webhook.URL, eventTypes, webhook.Secret, webhook.Active, time.Now(), webhook.ID)
+		if err != nil {
+			return err
+		}
+
+		after, err := webhookSnapshot(ctx, tx, webhook.ID)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "update", "webhook", webhook.ID, before, after)
+	})
+}
+
+func (m *PostgresDBRepo) DeleteWebhook(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := webhookSnapshot(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from webhooks where id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(ctx, tx, "delete", "webhook", id, before, nil)
+	})
+}
+
+// webhookSnapshot loads a webhook as it stands inside tx for the audit log, with its secret
+// cleared, and locks its row until tx ends.
+func webhookSnapshot(ctx context.Context, tx *sql.Tx, id int) (*models.Webhook, error) {
+	query := `select id, url, event_types, secret, active, created_at, updated_at from webhooks where id = $1 for update`
+	webhook, err := scanWebhook(tx.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.Secret = ""
+	return webhook, nil
 }
 
-func (m *PostgresDBRepo) CreateMovie(movie models.Movie) (int, error) {
+const webhookDeliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, coalesce(response_status, 0),
+last_error, next_attempt_at, delivered_at, created_at`
 
+func (m *PostgresDBRepo) InsertWebhookDelivery(delivery models.WebhookDelivery) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `insert into movies (title, description, release_date, runtime, mpaa_rating, image, created_at, updated_at) 
+	query := `insert into webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at, created_at, updated_at)
 values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
-	var newId int
-	result := m.DB.QueryRowContext(ctx, query, movie.Title, movie.Description, movie.ReleaseDate, movie.RunTime, movie.MPAARating, movie.Image, time.Now(), time.Now())
-	err := result.Scan(&newId)
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, delivery.WebhookID, int64(delivery.EventID), delivery.EventType, []byte(delivery.Payload),
+		delivery.Status, delivery.NextAttemptAt, time.Now(), time.Now()).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
 
-	return newId, nil
+	return id, nil
 }
 
-func (m *PostgresDBRepo) UpdateMovie(movie models.Movie) error {
-
+// UpdateWebhookDelivery records the outcome of an attempt made under the lease that ends at
+// leasedUntil. It returns sql.ErrNoRows, changing nothing, if the delivery is no longer pending
+// or has been claimed again since, so a late result cannot overwrite a newer one.
+func (m *PostgresDBRepo) UpdateWebhookDelivery(delivery models.WebhookDelivery, leasedUntil time.Time) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `update movies set title=$1, description=$2, release_date=$3, runtime=$4, mpaa_rating=$5, image=$6, updated_at=$7 where id = $8`
-	_, err := m.DB.ExecContext(ctx, query, movie.Title, movie.Description, movie.ReleaseDate, movie.RunTime, movie.MPAARating, movie.Image, time.Now(), movie.ID)
+	var responseStatus sql.NullInt64
+	if delivery.ResponseStatus != 0 {
+		responseStatus = sql.NullInt64{Int64: int64(delivery.ResponseStatus), Valid: true}
+	}
 
+	query := `update webhook_deliveries set status = $1, attempts = $2, response_status = $3, last_error = $4,
+next_attempt_at = $5, delivered_at = $6, updated_at = $7 where id = $8 and status = 'pending' and next_attempt_at = $9`
+	result, err := m.DB.ExecContext(ctx, query, delivery.Status, delivery.Attempts, responseStatus, delivery.LastError,
+		delivery.NextAttemptAt, delivery.DeliveredAt, time.Now(), delivery.ID, leasedUntil)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return expectRows(result)
 }
 
-func (m *PostgresDBRepo) Connection() *sql.DB {
-	return m.DB
+func (m *PostgresDBRepo) GetWebhookDelivery(webhookID, id int) (*models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + webhookDeliveryColumns + ` from webhook_deliveries where webhook_id = $1 and id = $2`
+	return scanWebhookDelivery(m.DB.QueryRowContext(ctx, query, webhookID, id))
 }
 
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error) {
+func (m *PostgresDBRepo) WebhookDeliveries(webhookID, limit, offset int) ([]*models.WebhookDelivery, error) {
+	query := `select ` + webhookDeliveryColumns + ` from webhook_deliveries where webhook_id = $1 order by id desc limit $2 offset $3`
+	return m.webhookDeliveries(query, webhookID, limit, offset)
+}
 
+// ClaimWebhookDeliveries leases up to limit pending deliveries that are due by moving their next
+// attempt to leaseUntil, so that dispatchers on other replicas skip them, and returns them.
+func (m *PostgresDBRepo) ClaimWebhookDeliveries(limit int, leaseUntil time.Time) ([]*models.WebhookDelivery, error) {
+	query := `update webhook_deliveries set next_attempt_at = $1, updated_at = $2
+where id in (
+	select id from webhook_deliveries
+	where status = 'pending' and next_attempt_at <= $2
+	order by next_attempt_at
+	limit $3
+	for update skip locked
+)
+returning ` + webhookDeliveryColumns
+	return m.webhookDeliveries(query, leaseUntil, time.Now(), limit)
+}
+
+func (m *PostgresDBRepo) webhookDeliveries(query string, args ...interface{}) ([]*models.WebhookDelivery, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
-	query := `select id, first_name, last_name, email, password, created_at, updated_at from users where email = $1`
-	var user models.User
-	row := m.DB.QueryRowContext(ctx, query, email)
-	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
 
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
+		log.Println(err)
 		return nil, err
 	}
-	return &user, nil
-}
+	defer rows.Close()
 
-func (m *PostgresDBRepo) GetUserByID(id int) (*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
-	defer cancel()
+	var deliveries []*models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
 
-	query := `select id, first_name, last_name, email, password, created_at, updated_at from users where id = $1`
-	var user models.User
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.CreatedAt, &user.UpdatedAt)
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
 
+func scanWebhookDelivery(row interface{ Scan(...interface{}) error }) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var eventID int64
+	var payload []byte
+	var nextAttemptAt, deliveredAt sql.NullTime
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &eventID, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts,
+		&delivery.ResponseStatus, &delivery.LastError, &nextAttemptAt, &deliveredAt, &delivery.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
-	return &user, nil
+
+	delivery.EventID = uint64(eventID)
+	delivery.Payload = payload
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+
+	return &delivery, nil
+}
+
+func nullJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
 }
 
-func (m *PostgresDBRepo) CreateMovieGenre(id int, genreIDs []int) error {
+// PersistedQueries returns the queries registered through the admin API by their hash.
+func (m *PostgresDBRepo) PersistedQueries() (map[string]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `delete from movies_genres where movie_id = $1`
+	rows, err := m.DB.QueryContext(ctx, `select hash, query from persisted_queries`)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
 
-	_, _ = m.DB.ExecContext(ctx, query, id)
+	queries := make(map[string]string)
+	for rows.Next() {
+		var hash, query string
+		if err := rows.Scan(&hash, &query); err != nil {
+			return nil, err
+		}
+		queries[hash] = query
+	}
 
-	for _, n := range genreIDs {
-		query := `insert into movies_genres (movie_id, genre_id) values ($1, $2)`
-		_, err := m.DB.ExecContext(ctx, query, id, n)
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return queries, nil
+}
 
+// InsertPersistedQuery stores a registered query and tells every replica to reload them once
+// the insert commits.
+func (m *PostgresDBRepo) InsertPersistedQuery(ctx context.Context, hash, query string) error {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `insert into persisted_queries (hash, query, created_at) values ($1, $2, $3) on conflict (hash) do nothing`,
+			hash, query, time.Now())
 		if err != nil {
 			return err
 		}
-	}
 
-	return nil
+		return invalidation.Notify(ctx, tx, invalidation.Message{Entity: invalidation.PersistedQuery})
+	})
 }
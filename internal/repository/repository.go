@@ -1,19 +1,85 @@
 ﻿package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"movie-library/internal/models"
+	"time"
+)
+
+var (
+	ErrVersionMismatch     = errors.New("movie has been modified since it was read")
+	ErrGenreExists         = errors.New("a genre with that name already exists")
+	ErrGenreInUse          = errors.New("genre is still used by movies")
+	ErrGenreHasSubgenres   = errors.New("genre still has subgenres")
+	ErrGenreCycle          = errors.New("a genre cannot be nested in itself or one of its subgenres")
+	ErrParentGenreNotFound = errors.New("parent genre does not exist")
+	ErrDuplicateExternalID = errors.New("another movie already has that external ID")
+	ErrSelfRelation        = errors.New("a movie cannot be related to itself")
 )
 
 type DatabaseRepo interface {
 	AllMovies(genre ...int) ([]*models.Movie, error)
+	SearchMovies(title string) ([]*models.Movie, error)
+	MoviesByIDs(ids []int) ([]*models.Movie, error)
+	MoviesPage(query models.MoviePageQuery) ([]*models.Movie, error)
 	Genres() ([]*models.Genre, error)
+	CreateGenre(ctx context.Context, genre string, parentID *int) (int, error)
+	GetGenre(id int) (*models.Genre, error)
+	UpdateGenre(ctx context.Context, id int, name string, parentID *int) error
+	MergeGenres(ctx context.Context, sourceID, targetID int) ([]int, error)
+	DeleteGenre(ctx context.Context, id int) error
+	GenresForMovies(movieIDs []int) (map[int][]*models.Genre, error)
+	MoviesForGenres(genreIDs []int, limit, offset int) (map[int][]*models.Movie, error)
+	Tags(prefix string, limit int) ([]*models.Tag, error)
+	TagsForMovies(movieIDs []int) (map[int][]*models.Tag, error)
+	MoviesByTags(names []string, genreID int) ([]*models.Movie, error)
+	SetMovieTags(ctx context.Context, id int, names []string) error
+	Collections() ([]*models.Collection, error)
+	GetCollection(id int) (*models.Collection, error)
+	MoviesForCollections(collectionIDs []int) (map[int][]*models.Movie, error)
+	InsertCollection(ctx context.Context, collection models.Collection) (int, error)
+	UpdateCollection(ctx context.Context, collection models.Collection) error
+	DeleteCollection(ctx context.Context, id int) error
+	CollectionEntriesForMovies(movieIDs []int) (map[int][]*models.CollectionEntry, error)
+	RelationsForMovies(movieIDs []int) (map[int][]*models.MovieRelation, error)
+	AddMovieRelation(ctx context.Context, movieID, relatedID int, relation string) error
+	RemoveMovieRelation(ctx context.Context, movieID, relatedID int, relation string) error
 	Connection() *sql.DB
 	GetUserByEmail(email string) (*models.User, error)
 	GetUserByID(id int) (*models.User, error)
 	GetMovieByID(id int) (*models.Movie, error)
-	DeleteMovie(id int) error
-	UpdateMovie(movie models.Movie) error
-	CreateMovie(movie models.Movie) (int, error)
-	CreateMovieGenre(id int, genreIDs []int) error
+	DeleteMovie(ctx context.Context, id int) error
+	DeletedMovies() ([]*models.Movie, error)
+	RestoreMovie(ctx context.Context, id int) error
+	PurgeMovie(ctx context.Context, id int) error
+	PurgeDeletedMovies(ctx context.Context, deletedBefore time.Time) ([]int, error)
+	UpdateMovie(ctx context.Context, movie models.Movie) error
+	RevertMovie(ctx context.Context, movie models.Movie) error
+	PatchMovie(ctx context.Context, id, version int, changes map[string]interface{}, genreIDs []int) error
+	CreateMovie(ctx context.Context, movie models.Movie) (int, error)
+	FindDuplicateMovie(movie models.Movie) (*models.Duplicate, error)
+	CreateMovieGenre(ctx context.Context, id int, genreIDs []int) error
+	MovieRevisions(movieID int) ([]*models.MovieRevision, error)
+	GetMovieRevision(movieID, revision int) (*models.MovieRevision, error)
+	AuditEntries(filter models.AuditFilter) ([]*models.AuditEntry, error)
+	ClaimOutboxEvents(limit int, leaseUntil time.Time) ([]*models.OutboxEvent, error)
+	GetOutboxEvent(id int64) (*models.OutboxEvent, error)
+	MarkOutboxEventPublished(id int64) error
+	RecordOutboxEventFailure(event models.OutboxEvent, retryAt time.Time) error
+	DeletePublishedOutboxEvents(publishedBefore time.Time) (int64, error)
+	AllWebhooks() ([]*models.Webhook, error)
+	ActiveWebhooks() ([]*models.Webhook, error)
+	GetWebhook(id int) (*models.Webhook, error)
+	InsertWebhook(ctx context.Context, webhook models.Webhook) (int, error)
+	UpdateWebhook(ctx context.Context, webhook models.Webhook) error
+	DeleteWebhook(ctx context.Context, id int) error
+	InsertWebhookDelivery(delivery models.WebhookDelivery) (int, error)
+	UpdateWebhookDelivery(delivery models.WebhookDelivery, leasedUntil time.Time) error
+	GetWebhookDelivery(webhookID, id int) (*models.WebhookDelivery, error)
+	WebhookDeliveries(webhookID, limit, offset int) ([]*models.WebhookDelivery, error)
+	ClaimWebhookDeliveries(limit int, leaseUntil time.Time) ([]*models.WebhookDelivery, error)
+	PersistedQueries() (map[string]string, error)
+	InsertPersistedQuery(ctx context.Context, hash, query string) error
 }
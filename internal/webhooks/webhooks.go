@@ -0,0 +1,288 @@
+﻿package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"movie-library/internal/events"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook secret, prefixed with "sha256=".
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign. Receivers should also reject timestamps
+// that are too old to guard against replays.
+func Verify(secret, signature string, timestamp int64, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+// Matches reports whether a webhook subscribes to eventType. An empty list or "*" matches
+// every event and "movie.*" matches every movie event.
+func Matches(webhook *models.Webhook, eventType string) bool {
+	if len(webhook.EventTypes) == 0 {
+		return true
+	}
+
+	for _, pattern := range webhook.EventTypes {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Dispatcher delivers bus events to the webhooks subscribed to them. Every delivery is
+// logged in the database; failed deliveries are retried with exponential backoff until
+// MaxAttempts is reached. Each attempt is made under a lease of Lease, which must outlast the
+// client timeout, so that dispatchers on other replicas do not attempt the same delivery.
+type Dispatcher struct {
+	DB           repository.DatabaseRepo
+	Client       *http.Client
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Lease        time.Duration
+	PollInterval time.Duration
+}
+
+func NewDispatcher(db repository.DatabaseRepo) *Dispatcher {
+	return &Dispatcher{
+		DB:           db,
+		Client:       &http.Client{Timeout: time.Second * 10},
+		MaxAttempts:  8,
+		BaseDelay:    time.Second * 30,
+		MaxDelay:     time.Hour * 6,
+		Lease:        time.Minute,
+		PollInterval: time.Second * 15,
+	}
+}
+
+// Run retries due deliveries until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.retryDue()
+		}
+	}
+}
+
+// Enqueue logs a pending delivery of event for every active webhook subscribed to it and
+// makes the first attempt in the background. It is used as an outbox sink.
+func (d *Dispatcher) Enqueue(event events.Event) error {
+	webhooks, err := d.DB.ActiveWebhooks()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		if !Matches(webhook, event.Type) {
+			continue
+		}
+
+		delivery := models.WebhookDelivery{
+			WebhookID: webhook.ID,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   payload,
+		}
+		err = d.create(&delivery)
+		if err != nil {
+			return err
+		}
+
+		go d.Attempt(webhook, &delivery)
+	}
+
+	return nil
+}
+
+// Redeliver sends the payload of an earlier delivery again as a new delivery and returns it
+// once the first attempt has finished.
+func (d *Dispatcher) Redeliver(webhook *models.Webhook, original *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	delivery := models.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   original.EventID,
+		EventType: original.EventType,
+		Payload:   original.Payload,
+	}
+	err := d.create(&delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Attempt(webhook, &delivery)
+	return &delivery, nil
+}
+
+func (d *Dispatcher) create(delivery *models.WebhookDelivery) error {
+	// the first attempt is made straight away under a lease, so no dispatcher retries it while
+	// it is in flight but one picks it up again if it never records its result
+	next := time.Now().Add(d.Lease)
+	delivery.Status = models.DeliveryPending
+	delivery.NextAttemptAt = &next
+	delivery.CreatedAt = time.Now()
+
+	id, err := d.DB.InsertWebhookDelivery(*delivery)
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+
+	return nil
+}
+
+// Attempt sends a delivery once and records the outcome, scheduling the next retry if it failed.
+func (d *Dispatcher) Attempt(webhook *models.Webhook, delivery *models.WebhookDelivery) {
+	leasedUntil := *delivery.NextAttemptAt
+	status, err := d.Send(context.Background(), webhook, delivery)
+
+	delivery.Attempts++
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	delivery.NextAttemptAt = nil
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliverySucceeded
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = models.DeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		next := now.Add(d.Backoff(delivery.Attempts))
+		delivery.Status = models.DeliveryPending
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = &next
+	}
+
+	d.record(delivery, leasedUntil)
+}
+
+// record saves the outcome of an attempt made under the lease ending at leasedUntil.
+func (d *Dispatcher) record(delivery *models.WebhookDelivery, leasedUntil time.Time) {
+	err := d.DB.UpdateWebhookDelivery(*delivery, leasedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("webhooks: delivery %d: lease expired before the attempt was recorded", delivery.ID)
+		return
+	}
+	if err != nil {
+		log.Println("webhooks:", err)
+	}
+}
+
+// Backoff returns the delay before the next attempt after the given number of failed attempts.
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.MaxDelay {
+			return d.MaxDelay
+		}
+	}
+
+	return delay
+}
+
+// Send posts a delivery to the webhook URL and returns the response status. Any status
+// outside 2xx is an error.
+func (d *Dispatcher) Send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "movie-library-webhooks")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) retryDue() {
+	deliveries, err := d.DB.ClaimWebhookDeliveries(100, time.Now().Add(d.Lease))
+	if err != nil {
+		log.Println("webhooks:", err)
+		return
+	}
+
+	webhooks := make(map[int]*models.Webhook)
+	for _, delivery := range deliveries {
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			webhook, err = d.DB.GetWebhook(delivery.WebhookID)
+			if err != nil {
+				log.Println("webhooks:", err)
+				continue
+			}
+			webhooks[delivery.WebhookID] = webhook
+		}
+
+		if !webhook.Active {
+			leasedUntil := *delivery.NextAttemptAt
+			delivery.Status = models.DeliveryFailed
+			delivery.LastError = "webhook is disabled"
+			delivery.NextAttemptAt = nil
+			d.record(delivery, leasedUntil)
+			continue
+		}
+		d.Attempt(webhook, delivery)
+	}
+}
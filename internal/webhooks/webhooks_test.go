@@ -0,0 +1,116 @@
+﻿package webhooks
+
+import (
+	"context"
+	"io"
+	"movie-library/internal/models"
+	"movie-library/internal/repository"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSendSignsPayload(t *testing.T) {
+	webhook := &models.Webhook{Secret: "s3cret"}
+	delivery := &models.WebhookDelivery{ID: 7, EventType: "movie.created", Payload: []byte(`{"id":1}`)}
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+
+		if !Verify(webhook.Secret, r.Header.Get(SignatureHeader), timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(EventHeader) != "movie.created" || r.Header.Get(DeliveryHeader) != "7" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	webhook.URL = receiver.URL
+
+	d := NewDispatcher(nil)
+	status, err := d.Send(context.Background(), webhook, delivery)
+	if err != nil || status != http.StatusNoContent {
+		t.Fatalf("got status %d, err %v", status, err)
+	}
+
+	status, err = d.Send(context.Background(), &models.Webhook{URL: receiver.URL, Secret: "other"}, delivery)
+	if err == nil || status != http.StatusUnauthorized {
+		t.Fatalf("expected the receiver to reject the signature, got status %d, err %v", status, err)
+	}
+}
+
+type deliveryRepo struct {
+	repository.DatabaseRepo
+	leasedUntil time.Time
+	saved       models.WebhookDelivery
+}
+
+func (r *deliveryRepo) UpdateWebhookDelivery(delivery models.WebhookDelivery, leasedUntil time.Time) error {
+	r.saved = delivery
+	r.leasedUntil = leasedUntil
+	return nil
+}
+
+func TestAttemptRecordsUnderLease(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	repo := &deliveryRepo{}
+	d := NewDispatcher(repo)
+	lease := time.Now().Add(d.Lease)
+	delivery := &models.WebhookDelivery{ID: 3, Status: models.DeliveryPending, NextAttemptAt: &lease}
+
+	d.Attempt(&models.Webhook{URL: receiver.URL}, delivery)
+
+	if !repo.leasedUntil.Equal(lease) {
+		t.Fatalf("recorded under lease %s, want %s", repo.leasedUntil, lease)
+	}
+	if repo.saved.Status != models.DeliveryPending || repo.saved.Attempts != 1 || repo.saved.NextAttemptAt == nil || repo.saved.NextAttemptAt.Equal(lease) {
+		t.Fatalf("expected a rescheduled retry, got %+v", repo.saved)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseDelay: time.Second, MaxDelay: time.Second * 10}
+
+	tests := map[int]time.Duration{
+		1: time.Second,
+		2: time.Second * 2,
+		4: time.Second * 8,
+		5: time.Second * 10,
+		9: time.Second * 10,
+	}
+	for attempts, want := range tests {
+		if got := d.Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		types     []string
+		eventType string
+		want      bool
+	}{
+		{nil, "movie.created", true},
+		{[]string{"*"}, "genre.created", true},
+		{[]string{"movie.created"}, "movie.created", true},
+		{[]string{"movie.created"}, "movie.updated", false},
+		{[]string{"movie.*"}, "movie.deleted", true},
+		{[]string{"movie.*"}, "genre.created", false},
+	}
+	for _, test := range tests {
+		if got := Matches(&models.Webhook{EventTypes: test.types}, test.eventType); got != test.want {
+			t.Errorf("Matches(%v, %q) = %v, want %v", test.types, test.eventType, got, test.want)
+		}
+	}
+}
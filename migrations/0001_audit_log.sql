@@ -0,0 +1,27 @@
+create table if not exists audit_log (
+    id          bigserial primary key,
+    actor_id    integer,
+    action      varchar(32)  not null,
+    entity_type varchar(32)  not null,
+    entity_id   integer      not null,
+    before      jsonb,
+    after       jsonb,
+    diff        jsonb,
+    request_id  varchar(128) not null default '',
+    created_at  timestamp    not null default now()
+);
+
+create index if not exists audit_log_entity_idx on audit_log (entity_type, entity_id);
+create index if not exists audit_log_actor_idx on audit_log (actor_id);
+create index if not exists audit_log_created_at_idx on audit_log (created_at);
+
+create or replace function audit_log_append_only() returns trigger as $$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+
+drop trigger if exists audit_log_no_update on audit_log;
+create trigger audit_log_no_update
+    before update or delete on audit_log
+    for each row execute function audit_log_append_only();
@@ -0,0 +1 @@
+alter table movies add column if not exists version integer not null default 1;
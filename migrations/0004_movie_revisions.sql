@@ -0,0 +1,9 @@
+create table if not exists movie_revisions (
+    id         bigserial primary key,
+    movie_id   integer   not null,
+    revision   integer   not null,
+    snapshot   jsonb     not null,
+    actor_id   integer,
+    created_at timestamp not null default now(),
+    unique (movie_id, revision)
+);
@@ -0,0 +1,28 @@
+create table if not exists webhooks (
+    id          serial primary key,
+    url         text      not null,
+    event_types jsonb     not null default '[]',
+    secret      text      not null,
+    active      boolean   not null default true,
+    created_at  timestamp not null default now(),
+    updated_at  timestamp not null default now()
+);
+
+create table if not exists webhook_deliveries (
+    id              bigserial primary key,
+    webhook_id      integer     not null references webhooks (id) on delete cascade,
+    event_id        bigint      not null,
+    event_type      varchar(64) not null,
+    payload         jsonb       not null,
+    status          varchar(16) not null default 'pending',
+    attempts        integer     not null default 0,
+    response_status integer,
+    last_error      text        not null default '',
+    next_attempt_at timestamp,
+    delivered_at    timestamp,
+    created_at      timestamp   not null default now(),
+    updated_at      timestamp   not null default now()
+);
+
+create index if not exists webhook_deliveries_webhook_idx on webhook_deliveries (webhook_id, id);
+create index if not exists webhook_deliveries_due_idx on webhook_deliveries (next_attempt_at) where status = 'pending';
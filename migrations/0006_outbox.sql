@@ -0,0 +1,14 @@
+create table if not exists outbox (
+    id           bigserial primary key,
+    entity_type  varchar(32) not null,
+    entity_id    integer     not null,
+    event_type   varchar(64) not null,
+    payload      jsonb       not null,
+    attempts     integer     not null default 0,
+    last_error   text        not null default '',
+    created_at   timestamp   not null default now(),
+    published_at timestamp
+);
+
+create index if not exists outbox_pending_idx on outbox (id) where published_at is null;
+create index if not exists outbox_published_at_idx on outbox (published_at);
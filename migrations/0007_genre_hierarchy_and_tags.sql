@@ -0,0 +1,20 @@
+alter table genres add column if not exists parent_id integer references genres (id);
+
+create index if not exists genres_parent_id_idx on genres (parent_id);
+
+create table if not exists tags (
+    id         serial primary key,
+    name       varchar(64) not null,
+    created_at timestamp   not null default now(),
+    updated_at timestamp   not null default now()
+);
+
+create unique index if not exists tags_name_idx on tags (lower(name));
+
+create table if not exists movies_tags (
+    movie_id integer not null references movies (id) on delete cascade,
+    tag_id   integer not null references tags (id) on delete cascade,
+    primary key (movie_id, tag_id)
+);
+
+create index if not exists movies_tags_tag_id_idx on movies_tags (tag_id);
@@ -0,0 +1,28 @@
+create table if not exists collections (
+    id          serial primary key,
+    name        varchar(255) not null,
+    description text         not null default '',
+    image       text         not null default '',
+    created_at  timestamp    not null default now(),
+    updated_at  timestamp    not null default now()
+);
+
+-- a movie belongs to at most one collection
+create table if not exists collection_movies (
+    collection_id integer not null references collections (id) on delete cascade,
+    movie_id      integer not null references movies (id) on delete cascade,
+    position      integer not null,
+    primary key (collection_id, movie_id),
+    unique (movie_id)
+);
+
+create table if not exists movie_relations (
+    movie_id         integer     not null references movies (id) on delete cascade,
+    related_movie_id integer     not null references movies (id) on delete cascade,
+    relation         varchar(16) not null check (relation in ('sequel_of', 'remake_of', 'spin_off')),
+    created_at       timestamp   not null default now(),
+    primary key (movie_id, related_movie_id, relation),
+    check (movie_id <> related_movie_id)
+);
+
+create index if not exists movie_relations_related_movie_id_idx on movie_relations (related_movie_id);
@@ -0,0 +1,11 @@
+alter table movies add column if not exists imdb_id varchar(16);
+alter table movies add column if not exists tmdb_id integer;
+alter table movies add column if not exists wikidata_id varchar(16);
+
+-- trashed movies keep their identifiers, so a restored movie cannot clash with a new one
+create unique index if not exists movies_imdb_id_idx on movies (imdb_id);
+create unique index if not exists movies_tmdb_id_idx on movies (tmdb_id);
+create unique index if not exists movies_wikidata_id_idx on movies (wikidata_id);
+
+-- duplicate detection compares titles ignoring case, spacing and punctuation
+create index if not exists movies_normalized_title_idx on movies (regexp_replace(lower(title), '[^[:alnum:]]+', '', 'g'));
@@ -0,0 +1,10 @@
+-- relays on several replicas claim events by leasing them until locked_until
+alter table outbox add column if not exists locked_until timestamp;
+-- sinks that already received the event, so a retry only goes to the ones that failed
+alter table outbox add column if not exists delivered_sinks jsonb not null default '[]';
+-- events that failed max_attempts times are dead-lettered and no longer relayed
+alter table outbox add column if not exists dead_at timestamp;
+
+drop index if exists outbox_pending_idx;
+create index if not exists outbox_pending_idx on outbox (id) where published_at is null and dead_at is null;
+create index if not exists outbox_pending_entity_idx on outbox (entity_type, entity_id, id) where published_at is null and dead_at is null;
@@ -0,0 +1,4 @@
+-- checkGenreName catches most clashes, but two concurrent creates or renames can both pass it;
+-- the index makes the database the final word. Merge any genres whose names differ only in
+-- case before applying it.
+create unique index if not exists genres_lower_genre_idx on genres (lower(genre));
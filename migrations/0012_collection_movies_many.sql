@@ -0,0 +1,4 @@
+-- a movie may belong to any number of collections
+alter table collection_movies drop constraint if exists collection_movies_movie_id_key;
+
+create index if not exists collection_movies_movie_id_idx on collection_movies (movie_id);
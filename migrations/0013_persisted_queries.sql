@@ -0,0 +1,6 @@
+-- queries registered through the admin API; every replica loads them into its allow list
+create table if not exists persisted_queries (
+    hash       char(64)  primary key,
+    query      text      not null,
+    created_at timestamp not null default now()
+);